@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cachedResponse a page of a releases API saved with the validators to check if it changed
+type cachedResponse struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Next         string    `json:"next,omitempty"`
+	Saved        time.Time `json:"saved"`
+	Body         string    `json:"body"`
+}
+
+// Get the directory release metadata is cached in, inside the data directory of the profile.
+func releaseCacheDir() string {
+	return filepath.Join(DATA, "cache")
+}
+
+// Get the cache file of a url, named after its hash.
+func (c *apiClient) cacheFile(pageURL string) string {
+	hash := sha256.Sum256([]byte(pageURL))
+	return filepath.Join(c.cacheDir, hex.EncodeToString(hash[:8])+".json")
+}
+
+// Read the cached response of a url, or nil if it isn't cached.
+func (c *apiClient) readCache(pageURL string) *cachedResponse {
+	if c.cacheDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.cacheFile(pageURL))
+	if err != nil {
+		return nil
+	}
+	var cached cachedResponse
+	if json.Unmarshal(data, &cached) != nil || cached.URL != pageURL {
+		return nil
+	}
+	return &cached
+}
+
+// Cache a response of a url along with its validators. Responses without validators are cached
+// as well, so that they can still be used when the API can't be reached.
+func (c *apiClient) writeCache(pageURL string, resp *http.Response, body []byte, next string) {
+	if c.cacheDir == "" {
+		return
+	}
+	cached := cachedResponse{
+		URL:          pageURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Next:         next,
+		Saved:        time.Now(),
+		Body:         string(body),
+	}
+	data, err := json.Marshal(cached)
+	check(err)
+	err = os.MkdirAll(c.cacheDir, 0755)
+	check(err)
+	// Written without the lock by list, so readers must never see a partly written file
+	err = writeFileAtomic(c.cacheFile(pageURL), data)
+	check(err)
+}
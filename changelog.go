@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Patterns of the markdown of release notes that are rewritten as plain text
+var (
+	markdownComment   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	markdownImage     = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink      = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
+	markdownEmphasis  = regexp.MustCompile(`(\*\*|__|~~)(.+?)(\*\*|__|~~)`)
+	markdownCode      = regexp.MustCompile("`([^`]+)`")
+	markdownHeading   = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	markdownBullet    = regexp.MustCompile(`^(\s*)[*+-]\s+`)
+	markdownBlankRuns = regexp.MustCompile(`\n{3,}`)
+)
+
+// Get the releases whose notes make up the changelog from the active version to a release,
+// newest first. Without an active version, or when going back to an older version, only the
+// notes of the release itself apply.
+func changelog(tags []Tag, target Tag) []Tag {
+	current := config.CurrentVersion
+	if current == "" || compareVersions(target.Version, current) <= 0 {
+		return []Tag{target}
+	}
+	var releases []Tag
+	for _, tag := range tags {
+		if tag.Draft || compareVersions(tag.Version, current) <= 0 || compareVersions(tag.Version, target.Version) > 0 {
+			continue
+		}
+		// Prereleases of other versions are left out unless the target is one
+		if tag.Prerelease && !target.Prerelease && tag.Version != target.Version {
+			continue
+		}
+		releases = append(releases, tag)
+	}
+	sort.SliceStable(releases, func(i, j int) bool {
+		return compareVersions(releases[i].Version, releases[j].Version) > 0
+	})
+	return releases
+}
+
+// Print the notes of the releases from the active version to a release, and emit them as
+// release-notes events.
+func printChangelog(tags []Tag, target Tag) {
+	for _, tag := range changelog(tags, target) {
+		notes := renderMarkdown(tag.Body)
+		emit(Event{Event: "release-notes", Version: tag.Version, Notes: notes})
+		title := tag.Version
+		if tag.Name != "" && tag.Name != tag.Version {
+			title += " - " + tag.Name
+		}
+		if !tag.PublishedAt.IsZero() {
+			title += " (" + tag.PublishedAt.Local().Format("2006-01-02") + ")"
+		}
+		fmt.Printf("\n%s\n%s\n", title, strings.Repeat("-", len(title)))
+		if notes == "" {
+			fmt.Println("No release notes")
+			continue
+		}
+		for _, line := range strings.Split(notes, "\n") {
+			fmt.Println("  " + line)
+		}
+	}
+	fmt.Println()
+}
+
+// Render the markdown of release notes as plain text for the terminal.
+func renderMarkdown(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	body = markdownComment.ReplaceAllString(body, "")
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue
+		}
+		if match := markdownHeading.FindStringSubmatch(line); match != nil {
+			line = strings.ToUpper(match[1])
+		}
+		line = markdownBullet.ReplaceAllString(line, "$1- ")
+		line = markdownImage.ReplaceAllString(line, "$1")
+		line = markdownLink.ReplaceAllStringFunc(line, func(link string) string {
+			match := markdownLink.FindStringSubmatch(link)
+			if match[1] == match[2] {
+				return match[1]
+			}
+			return match[1] + " (" + match[2] + ")"
+		})
+		line = markdownEmphasis.ReplaceAllString(line, "$2")
+		line = markdownCode.ReplaceAllString(line, "$1")
+		lines = append(lines, line)
+	}
+	text := markdownBlankRuns.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// Let the user confirm installing a release after reading its changelog, or pick another
+// version. Returns the release to install.
+func confirmRelease(tags []Tag, target Tag) Tag {
+	if argNonInteractive {
+		return target
+	}
+	for {
+		fmt.Printf("Install %s? Press enter to install it, enter another version to see it, or n to cancel: ", target.Version)
+		var input string
+		fmt.Scanln(&input)
+		input = strings.TrimSpace(input)
+		switch {
+		case input == "" || strings.EqualFold(input, "y"):
+			return target
+		case strings.EqualFold(input, "n"):
+			fmt.Println("Update cancelled")
+			exit(ExitOK)
+		default:
+			tag, found := findVersion(tags, input)
+			if !found {
+				fmt.Printf("Version %s not found\n", input)
+				continue
+			}
+			target = tag
+			printChangelog(tags, target)
+		}
+	}
+}
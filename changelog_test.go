@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+// Test that release notes are rendered as plain text.
+func TestRenderMarkdown(t *testing.T) {
+	body := "## What's Changed\r\n\r\n* **Naruto** combo fixed by @user in [#12](https://github.com/a/b/pull/12)\r\n" +
+		"  + `Sasuke` nerfed\r\n\r\n\r\n\r\n<!-- hidden -->![screenshot](shot.png)\r\n```\r\ncode\r\n```\r\nSee https://example.com"
+	expected := "WHAT'S CHANGED\n\n- Naruto combo fixed by @user in #12 (https://github.com/a/b/pull/12)\n" +
+		"  - Sasuke nerfed\n\nscreenshot\ncode\nSee https://example.com"
+	if text := renderMarkdown(body); text != expected {
+		t.Errorf("Expected:\n%s\nbut got:\n%s", expected, text)
+	}
+}
+
+// Test that the changelog covers the releases after the active version up to the target.
+func TestChangelog(t *testing.T) {
+	defer func() { config = Config{} }()
+	tags := []Tag{
+		{Version: "v1.3.0-beta", Prerelease: true},
+		{Version: "v1.2.0"},
+		{Version: "v1.2.0-beta", Prerelease: true},
+		{Version: "v1.1.0"},
+		{Version: "v1.0.0"},
+	}
+	config.CurrentVersion = "v1.0.0"
+	releases := changelog(tags, tags[1])
+	if len(releases) != 2 || releases[0].Version != "v1.2.0" || releases[1].Version != "v1.1.0" {
+		t.Errorf("Unexpected changelog %v", releases)
+	}
+	releases = changelog(tags, tags[0])
+	if len(releases) != 4 || releases[0].Version != "v1.3.0-beta" {
+		t.Errorf("Expected the prereleases in the changelog of a prerelease but got %v", releases)
+	}
+	config.CurrentVersion = "v1.2.0"
+	releases = changelog(tags, tags[3])
+	if len(releases) != 1 || releases[0].Version != "v1.1.0" {
+		t.Errorf("Expected only the notes of an older version but got %v", releases)
+	}
+}
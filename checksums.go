@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ChecksumsAsset the name of the release asset listing the SHA-256 checksums of the other assets
+var ChecksumsAsset = "SHA256SUMS"
+
+// SignatureAsset the name of the release asset with the minisign signature of the ChecksumsAsset
+var SignatureAsset = "SHA256SUMS.minisig"
+
+// checksums the SHA-256 checksums of the assets of a release by asset name
+type checksums map[string]string
+
+// Get the checksums of the assets of a release. If a public key is given, such as the one
+// publicKeyFor returns for the repository, the checksums must be signed with it and releases
+// without signed checksums are refused. Returns nil if the release has no checksums and no key
+// is given.
+func fetchChecksums(tag Tag, publicKey string) checksums {
+	checksumsAsset, hasChecksums := findAsset(tag, ChecksumsAsset)
+	if !hasChecksums {
+		if publicKey != "" {
+			fail(ExitSignatureInvalid, "Refusing to install %s: it has no signed %s", tag.Version, ChecksumsAsset)
+		}
+		fmt.Printf("Release %s has no %s, skipping checksum verification\n", tag.Version, ChecksumsAsset)
+		return nil
+	}
+	checksumsPath := filepath.Join(runDir(), ChecksumsAsset)
+	defer os.Remove(checksumsPath)
+	if publicKey == "" {
+		// Nothing vouches for the checksums of a mirror, so they only come from the release itself
+		downloadAsset(checksumsAsset, checksumsPath, nil)
+	} else {
+		key, err := parseMinisignKey(publicKey)
+		if err != nil {
+			fail(ExitSignatureInvalid, "Unable to read the public key to verify %s with: %s", tag.Version, err.Error())
+		}
+		signatureAsset, hasSignature := findAsset(tag, SignatureAsset)
+		if !hasSignature {
+			fail(ExitSignatureInvalid, "Refusing to install %s: it has no %s", tag.Version, SignatureAsset)
+		}
+		signaturePath := filepath.Join(runDir(), SignatureAsset)
+		defer os.Remove(signaturePath)
+		fetchAsset(signatureAsset, append([]string{signatureAsset.DownloadURL}, signatureAsset.Mirrors...), signaturePath, ExitSignatureInvalid, nil)
+		signature := readFile(signaturePath)
+		// The signature vouches for the checksums wherever they come from
+		fetchAsset(checksumsAsset, append([]string{checksumsAsset.DownloadURL}, checksumsAsset.Mirrors...), checksumsPath, ExitSignatureInvalid, func(path string) error {
+			manifest, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := verifyMinisign(key, manifest, signature); err != nil {
+				return fmt.Errorf("refusing to install %s, invalid signature: %s", tag.Version, err.Error())
+			}
+			return nil
+		})
+		fmt.Printf("Verified the signature of %s\n", ChecksumsAsset)
+	}
+	manifest, err := os.ReadFile(checksumsPath)
+	check(err)
+	return parseChecksums(string(manifest))
+}
+
+// Parse checksums in the format of sha256sum, e.g. "<hash>  patches.zip" or "<hash> *patches.zip".
+// Everything after the hash and the separator is the file name, which may contain spaces.
+func parseChecksums(manifest string) checksums {
+	sums := checksums{}
+	scanner := bufio.NewScanner(strings.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		hashSize := sha256.Size * 2
+		if len(line) < hashSize+2 || line[hashSize] != ' ' {
+			continue
+		}
+		hash := line[:hashSize]
+		if _, err := hex.DecodeString(hash); err != nil {
+			continue
+		}
+		// sha256sum separates the name with a space for text mode or a * for binary mode
+		name := line[hashSize+1:]
+		if len(name) > 1 && (name[0] == ' ' || name[0] == '*') {
+			name = name[1:]
+		}
+		sums[name] = strings.ToLower(hash)
+	}
+	return sums
+}
+
+// Verify that a downloaded asset matches its checksum. Once a release has checksums, every asset
+// used from it must be listed.
+func (sums checksums) verify(assetName string, filePath string) error {
+	if sums == nil {
+		return nil
+	}
+	expected, ok := sums[assetName]
+	if !ok {
+		return fmt.Errorf("%s is not listed in %s", assetName, ChecksumsAsset)
+	}
+	actual, err := sha256File(filePath)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%s has SHA-256 %s but expected %s", assetName, actual, expected)
+	}
+	return nil
+}
+
+// Get the public key to verify releases of a repository with: the one set in the config, or
+// the one of the profile when the repository is the profile's own.
+func publicKeyFor(repo string) string {
+	if config.PublicKey != "" {
+		return config.PublicKey
+	}
+	if repo == profile.Repository {
+		return profile.PublicKey
+	}
+	return ""
+}
+
+// Find an asset of a release by name.
+func findAsset(tag Tag, name string) (Asset, bool) {
+	for _, asset := range tag.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Get the SHA-256 hash of a file as a hex string.
+func sha256File(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// CISOHeaderSize the size of the header of a CISO: the magic, the block size and the block map
+const CISOHeaderSize = 0x8000
+
+// CISOBlockSize the size of the blocks of written CISOs, the size Nintendont and Dolphin write
+var CISOBlockSize int64 = 0x200000
+
+// cisoWriter writes a disc image as a CISO, leaving out the blocks that are all zeroes. The last
+// block is padded with zeroes to the block size. The block map is written last, since it is only
+// known then.
+type cisoWriter struct {
+	blockWriter
+	unreadableImage
+	output io.WriteSeeker
+	header []byte
+}
+
+// Start writing a disc image of a size as a CISO with blocks of a size.
+func newCISOWriter(output io.WriteSeeker, size int64, blockSize int64) (*cisoWriter, error) {
+	count := (size + blockSize - 1) / blockSize
+	if count > CISOHeaderSize-8 {
+		return nil, fmt.Errorf("a %d byte image doesn't fit in a CISO with %d byte blocks", size, blockSize)
+	}
+	w := &cisoWriter{output: output, header: make([]byte, CISOHeaderSize)}
+	copy(w.header, "CISO")
+	binary.LittleEndian.PutUint32(w.header[4:], uint32(blockSize))
+	w.blockWriter = blockWriter{size: size, block: make([]byte, blockSize), writeBlock: w.writeBlock}
+	if _, err := output.Seek(CISOHeaderSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *cisoWriter) writeBlock(index int, block []byte) error {
+	if isZeroes(block) {
+		return nil
+	}
+	w.header[8+index] = 1
+	if _, err := w.output.Write(block); err != nil {
+		return err
+	}
+	_, err := w.output.Write(make([]byte, len(w.block)-len(block)))
+	return err
+}
+
+func (w *cisoWriter) finish() error {
+	if err := w.finishBlocks(); err != nil {
+		return err
+	}
+	if _, err := w.output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.output.Write(w.header)
+	return err
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// command a subcommand of the updater
+type command struct {
+	usage       string
+	description string
+	run         func(args []string)
+}
+
+// commands the subcommands by name. Without a subcommand, update is run.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"update":      {"[iso]", "Download the newest release and patch the vanilla ISO with it (default)", updateCommand},
+		"patch":       {"<iso> <xdelta> <output>", "Patch an ISO with a local xdelta patch, without downloading anything", patchCommand},
+		"verify":      {"<iso>", "Check whether an ISO is a vanilla ISO that can be patched", verifyCommand},
+		"list":        {"", "List the available releases and their assets", listCommand},
+		"info":        {"<iso>", "Print the disc header and identification of an ISO", infoCommand},
+		"versions":    {"list | use <version> | rollback | delete <version>... | prune", "Manage the installed versions and their ISOs", versionsCommand},
+		"saves":       {"list | backup | restore [backup]", "Back up and restore the saves of the game in Dolphin", savesCommand},
+		"self-update": {"", "Update Six Patches of Pain itself to its newest release", selfUpdateCommand},
+		"config":      {"get [key] | set <key> <value> | unset <key>", "Print or change the settings", configCommand},
+		"help":        {"", "Print this help", helpCommand},
+	}
+}
+
+// Create the flags for a command, including the flags shared by every command.
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&argProfile, "profile", "", "Specify the profile of the base game and mod to use")
+	flags.BoolVar(&argNonInteractive, "yes", false, "Never prompt for input, fail with a distinct exit code instead")
+	flags.BoolVar(&argNonInteractive, "non-interactive", false, "Same as -yes")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] %s\n", ExecutableName, name, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Print the commands and how to use them.
+func helpCommand(args []string) {
+	fmt.Printf("Usage: %s [command] [flags] [arguments]\n\n", ExecutableName)
+	fmt.Println("Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %-11s %s\n", name, commands[name].description)
+		if commands[name].usage != "" {
+			fmt.Printf("              %s %s %s\n", ExecutableName, name, commands[name].usage)
+		}
+	}
+	fmt.Printf("\nRun %s <command> -h for the flags of a command.\n", ExecutableName)
+}
+
+// Patch an ISO with a local xdelta patch. Known dumps of the base game are normalized first.
+func patchCommand(args []string) {
+	defer recoverFailure()
+	flags := newFlagSet("patch", commands["patch"].usage)
+	flags.BoolVar(&argJSON, "json", false, "Write newline-delimited JSON events to stdout instead of text, implies -yes")
+	flags.Parse(args)
+	if flags.NArg() != 3 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	if argJSON {
+		enableJSONEvents()
+	}
+	isoPath, patchPath, outputPath := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	selectProfile(isoPath)
+	emit(Event{Event: "start", Version: Version, Profile: profile.Name})
+	if !exists(isoPath) {
+		fail(ExitNoISO, "ISO does not exist: %s", isoPath)
+	}
+	if !exists(patchPath) {
+		fail(ExitFailure, "Patch does not exist: %s", patchPath)
+	}
+	baseIso := Iso{filePath: isoPath, isFile: true}
+	isBase, isoBytes := isBaseGame(isoPath)
+	if isoBytes != nil {
+		baseIso = Iso{filePath: isoPath, bytes: isoBytes, isFile: false}
+	} else if !isBase {
+		fmt.Printf("%s is not a vanilla %s ISO, patching it as is\n", isoPath, profile.GameName)
+	}
+	emit(Event{Event: "iso-detected", Path: isoPath})
+	patchBaseISO(baseIso, patchPath, outputPath, "iso", nil)
+	outputFullPath, err := filepath.Abs(outputPath)
+	check(err)
+	emit(Event{Event: "completed", Path: outputFullPath})
+	exit(ExitOK)
+}
+
+// Check whether an ISO is the vanilla base game, or a known dump that can be normalized to it.
+func verifyCommand(args []string) {
+	defer recoverFailure()
+	flags := newFlagSet("verify", commands["verify"].usage)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	argNonInteractive = true
+	isoPath := flags.Arg(0)
+	selectProfile(isoPath)
+	if !exists(isoPath) {
+		fail(ExitNoISO, "ISO does not exist: %s", isoPath)
+	}
+	hash, isBase, rule := identifyBaseGame(isoPath)
+	switch {
+	case hash == "":
+		fail(ExitNoISO, "%s is not a %s ISO", isoPath, profile.GameName)
+	case !isBase:
+		fail(ExitChecksumMismatch, "%s is a modified or unknown dump of %s (CRC32 %s)", isoPath, profile.GameName, hash)
+	case rule != nil:
+		fmt.Printf("%s is a %s of %s (CRC32 %s), it will be converted before patching\n", isoPath, rule.Name, profile.GameName, hash)
+	default:
+		fmt.Printf("%s is a vanilla %s ISO (CRC32 %s)\n", isoPath, profile.GameName, hash)
+	}
+}
+
+// List the releases of the configured repository with their assets.
+func listCommand(args []string) {
+	flags := newFlagSet("list", commands["list"].usage)
+	flags.StringVar(&argGitRepository, "r", "", "Specify git repository to list releases of")
+	flags.StringVar(&argChannel, "channel", "", "Mark the latest release of the stable, beta or nightly channel instead of the configured one")
+	flags.Parse(args)
+	argNonInteractive = true
+	selectProfile()
+	loadDataDir()
+	repo := config.Repository
+	if argGitRepository != "" {
+		repo = argGitRepository
+	}
+	tags := fetchAllTags(repo)
+	if len(tags) == 0 {
+		fail(ExitFailure, "No releases found at %s", repo)
+	}
+	latest, _ := selectLatest(tags, releaseChannel())
+	for _, tag := range tags {
+		description := describeTag(tag)
+		if tag.Version == latest.Version {
+			description = strings.TrimSuffix("latest, "+description, ", ")
+		}
+		if tag.Version == config.CurrentVersion {
+			description = strings.TrimSuffix("current, "+description, ", ")
+		}
+		if description != "" {
+			fmt.Printf("%s (%s)\n", tag.Version, description)
+		} else {
+			fmt.Println(tag.Version)
+		}
+		for _, asset := range tag.Assets {
+			fmt.Printf("  %-30s %10s\n", asset.Name, formatBytes(asset.Size))
+		}
+	}
+}
+
+// DiscHeader the identifying fields of a GameCube or Wii disc header
+type DiscHeader struct {
+	GameID       string
+	DiscNumber   byte
+	Version      byte
+	Name         string
+	DOLOffset    uint32
+	FSTOffset    uint32
+	FSTSize      uint32
+	IsGameCube   bool
+	IsWii        bool
+	HeaderOffset int64
+}
+
+// Print the disc header of an ISO and what it was identified as.
+func infoCommand(args []string) {
+	defer recoverFailure()
+	flags := newFlagSet("info", commands["info"].usage)
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	argNonInteractive = true
+	isoPath := flags.Arg(0)
+	if !exists(isoPath) {
+		fail(ExitNoISO, "ISO does not exist: %s", isoPath)
+	}
+	selectProfile(isoPath)
+	header, err := readDiscHeader(isoPath)
+	if err != nil {
+		fail(ExitFailure, "Unable to read disc header of %s: %s", isoPath, err.Error())
+	}
+	format := "ISO"
+	if isCISO(isoPath) {
+		format = "CISO"
+	} else if isNkit(isoPath) {
+		format = "NKIT"
+	}
+	platform := "Unknown"
+	if header.IsGameCube {
+		platform = "GameCube"
+	} else if header.IsWii {
+		platform = "Wii"
+	}
+	fmt.Printf("File:        %s\n", isoPath)
+	fmt.Printf("Size:        %s\n", formatBytes(getFileSize(isoPath)))
+	fmt.Printf("Format:      %s\n", format)
+	fmt.Printf("Platform:    %s\n", platform)
+	fmt.Printf("Game ID:     %s\n", header.GameID)
+	fmt.Printf("Name:        %s\n", header.Name)
+	fmt.Printf("Disc:        %d\n", header.DiscNumber+1)
+	fmt.Printf("Version:     1.%02d\n", header.Version)
+	fmt.Printf("DOL offset:  0x%X\n", header.DOLOffset)
+	fmt.Printf("FST offset:  0x%X\n", header.FSTOffset)
+	fmt.Printf("FST size:    0x%X\n", header.FSTSize)
+	if header.GameID != profile.GameID {
+		fmt.Printf("Identified:  not %s\n", profile.GameName)
+		return
+	}
+	hash, isBase, rule := identifyBaseGame(isoPath)
+	fmt.Printf("CRC32:       %s\n", hash)
+	switch {
+	case !isBase:
+		fmt.Printf("Identified:  modified or unknown dump of %s\n", profile.GameName)
+	case rule != nil:
+		fmt.Printf("Identified:  %s of %s\n", rule.Name, profile.GameName)
+	default:
+		fmt.Printf("Identified:  vanilla %s\n", profile.GameName)
+	}
+}
+
+// Read the disc header of an ISO, looking past the header of a CISO.
+func readDiscHeader(filePath string) (DiscHeader, error) {
+	header := DiscHeader{}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return header, err
+	}
+	defer f.Close()
+	if isCISO(filePath) {
+		header.HeaderOffset = CISOHeaderSize
+	}
+	data := make([]byte, 0x440)
+	_, err = f.ReadAt(data, header.HeaderOffset)
+	if err != nil {
+		return header, err
+	}
+	header.GameID = string(data[:6])
+	header.DiscNumber = data[6]
+	header.Version = data[7]
+	header.IsWii = binary.BigEndian.Uint32(data[0x18:]) == 0x5D1C9EA3
+	header.IsGameCube = binary.BigEndian.Uint32(data[0x1C:]) == 0xC2339F3D
+	name := data[0x20:0x400]
+	if end := strings.IndexByte(string(name), 0); end >= 0 {
+		name = name[:end]
+	}
+	header.Name = string(name)
+	header.DOLOffset = binary.BigEndian.Uint32(data[0x420:])
+	header.FSTOffset = binary.BigEndian.Uint32(data[0x424:])
+	header.FSTSize = binary.BigEndian.Uint32(data[0x428:])
+	return header, nil
+}
+
+// Format a number of bytes with an SI prefix, e.g. 1.4 GB.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
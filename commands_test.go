@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create the disc header of a GameCube ISO.
+func testDiscHeader() []byte {
+	data := make([]byte, 0x440)
+	copy(data, "G4NJDA")
+	data[6] = 0
+	data[7] = 2
+	binary.BigEndian.PutUint32(data[0x1C:], 0xC2339F3D)
+	copy(data[0x20:], "NARUTO GEKITOU NINJA TAISEN! 4")
+	binary.BigEndian.PutUint32(data[0x420:], 0x1E800)
+	binary.BigEndian.PutUint32(data[0x424:], 0x245C00)
+	binary.BigEndian.PutUint32(data[0x428:], 0x2500)
+	return data
+}
+
+// Test that the disc header is read from a plain ISO and from past the header of a CISO.
+func TestReadDiscHeader(t *testing.T) {
+	dir := t.TempDir()
+	isoPath := filepath.Join(dir, "game.iso")
+	os.WriteFile(isoPath, testDiscHeader(), 0644)
+	cisoPath := filepath.Join(dir, "game.ciso")
+	ciso := make([]byte, CISOHeaderSize)
+	copy(ciso, "CISO")
+	os.WriteFile(cisoPath, append(ciso, testDiscHeader()...), 0644)
+
+	for _, test := range []struct {
+		path   string
+		offset int64
+	}{{isoPath, 0}, {cisoPath, CISOHeaderSize}} {
+		header, err := readDiscHeader(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.HeaderOffset != test.offset {
+			t.Errorf("%s: expected the header at %#x but got %#x", test.path, test.offset, header.HeaderOffset)
+		}
+		if header.GameID != "G4NJDA" || header.Version != 2 || !header.IsGameCube || header.IsWii {
+			t.Errorf("%s: unexpected header %+v", test.path, header)
+		}
+		if header.Name != "NARUTO GEKITOU NINJA TAISEN! 4" {
+			t.Errorf("%s: expected the name without padding but got %q", test.path, header.Name)
+		}
+		if header.DOLOffset != 0x1E800 || header.FSTOffset != 0x245C00 || header.FSTSize != 0x2500 {
+			t.Errorf("%s: unexpected offsets %+v", test.path, header)
+		}
+	}
+
+	if _, err := readDiscHeader(filepath.Join(dir, "missing.iso")); err == nil {
+		t.Error("Expected an error for a missing ISO")
+	}
+}
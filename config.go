@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigFile the config file holding the settings and state of the data folder
+var ConfigFile = "data/config.json"
+
+// ConfigVersion the version of the config file format written by this build
+const ConfigVersion = 2
+
+// Channels the release channels that can be followed
+var Channels = []string{"stable", "beta", "nightly"}
+
+// config the loaded config of the data folder
+var config Config
+
+type Config struct {
+	Version           int               `json:"version"`
+	Repository        string            `json:"repository"`
+	ISOPath           string            `json:"iso_path"`
+	OutputDir         string            `json:"output_dir"`
+	Channel           string            `json:"channel"`
+	CurrentVersion    string            `json:"current_version"`
+	InstalledVersions []Installation    `json:"installed_versions"`
+	PublicKey         string            `json:"public_key,omitempty"`
+	GitHubToken       string            `json:"github_token,omitempty"`
+	Retries           *int              `json:"retries,omitempty"`
+	Timeout           int               `json:"timeout,omitempty"`
+	Mirrors           []string          `json:"mirrors,omitempty"`
+	Proxy             string            `json:"proxy,omitempty"`
+	CABundle          string            `json:"ca_bundle,omitempty"`
+	ConnectTimeout    int               `json:"connect_timeout,omitempty"`
+	Dolphin           string            `json:"dolphin,omitempty"`
+	DolphinDir        string            `json:"dolphin_dir,omitempty"`
+	DolphinFiles      map[string]string `json:"dolphin_files,omitempty"`
+	DolphinPath       string            `json:"dolphin_path,omitempty"`
+	Launch            string            `json:"launch,omitempty"`
+	OutputFormat      string            `json:"output_format,omitempty"`
+	NintendontDir     string            `json:"nintendont_dir,omitempty"`
+	NintendontFormat  string            `json:"nintendont_format,omitempty"`
+}
+
+// configKey a setting of the config that can be read and written with the config command
+type configKey struct {
+	get func() string
+	set func(value string) error
+}
+
+var configKeys = map[string]configKey{
+	"repository": {
+		get: func() string { return config.Repository },
+		set: func(value string) error {
+			if value == "" {
+				value = GitRepository
+			}
+			config.Repository = value
+			return nil
+		},
+	},
+	"iso_path": {
+		get: func() string { return config.ISOPath },
+		set: func(value string) error {
+			if value == "" {
+				value = GNT4ISO
+			}
+			config.ISOPath = value
+			return nil
+		},
+	},
+	"output_dir": {
+		get: func() string { return config.OutputDir },
+		set: func(value string) error {
+			config.OutputDir = value
+			return nil
+		},
+	},
+	"channel": {
+		get: func() string { return config.Channel },
+		set: func(value string) error {
+			if value == "" {
+				value = Channels[0]
+			}
+			for _, channel := range Channels {
+				if channel == value {
+					config.Channel = value
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown channel %s, expected one of %s", value, strings.Join(Channels, ", "))
+		},
+	},
+	"current_version": {
+		get: func() string { return config.CurrentVersion },
+		set: func(value string) error {
+			config.CurrentVersion = value
+			return nil
+		},
+	},
+	"github_token": {
+		get: func() string { return config.GitHubToken },
+		set: func(value string) error {
+			config.GitHubToken = value
+			return nil
+		},
+	},
+	"public_key": {
+		get: func() string { return config.PublicKey },
+		set: func(value string) error {
+			if value != "" {
+				if _, err := parseMinisignKey(value); err != nil {
+					return err
+				}
+			}
+			config.PublicKey = value
+			return nil
+		},
+	},
+	"retries": {
+		get: func() string {
+			if config.Retries == nil {
+				return ""
+			}
+			return strconv.Itoa(*config.Retries)
+		},
+		set: func(value string) error {
+			if value == "" {
+				config.Retries = nil
+				return nil
+			}
+			retries := 0
+			if err := setConfigInt(&retries, value); err != nil {
+				return err
+			}
+			config.Retries = &retries
+			return nil
+		},
+	},
+	"timeout": {
+		get: func() string { return strconv.Itoa(config.Timeout) },
+		set: func(value string) error { return setConfigInt(&config.Timeout, value) },
+	},
+	"mirrors": {
+		get: func() string { return strings.Join(config.Mirrors, ",") },
+		set: func(value string) error {
+			mirrors, err := parseMirrors(value)
+			if err != nil {
+				return err
+			}
+			config.Mirrors = mirrors
+			return nil
+		},
+	},
+	"proxy": {
+		get: func() string { return config.Proxy },
+		set: func(value string) error {
+			if value != "" {
+				proxyURL, err := url.Parse(value)
+				if err != nil {
+					return err
+				}
+				if proxyURL.Scheme == "" || proxyURL.Host == "" {
+					return fmt.Errorf("expected a proxy url such as http://proxy.example.com:8080")
+				}
+			}
+			config.Proxy = value
+			return nil
+		},
+	},
+	"ca_bundle": {
+		get: func() string { return config.CABundle },
+		set: func(value string) error {
+			if value != "" {
+				if _, err := loadCABundle(value); err != nil {
+					return err
+				}
+			}
+			config.CABundle = value
+			return nil
+		},
+	},
+	"connect_timeout": {
+		get: func() string { return strconv.Itoa(config.ConnectTimeout) },
+		set: func(value string) error { return setConfigInt(&config.ConnectTimeout, value) },
+	},
+	"dolphin": {
+		get: func() string { return config.Dolphin },
+		set: func(value string) error {
+			if value == "" {
+				value = DolphinModes[0]
+			}
+			for _, mode := range DolphinModes {
+				if mode == value {
+					config.Dolphin = value
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown Dolphin mode %s, expected one of %s", value, strings.Join(DolphinModes, ", "))
+		},
+	},
+	"dolphin_dir": {
+		get: func() string { return config.DolphinDir },
+		set: func(value string) error {
+			config.DolphinDir = value
+			return nil
+		},
+	},
+	"dolphin_path": {
+		get: func() string { return config.DolphinPath },
+		set: func(value string) error {
+			config.DolphinPath = value
+			return nil
+		},
+	},
+	"launch": {
+		get: func() string { return config.Launch },
+		set: func(value string) error {
+			if value == "" {
+				value = LaunchModes[0]
+			}
+			for _, mode := range LaunchModes {
+				if mode == value {
+					config.Launch = value
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown launch mode %s, expected one of %s", value, strings.Join(LaunchModes, ", "))
+		},
+	},
+	"output_format": {
+		get: func() string { return config.OutputFormat },
+		set: func(value string) error {
+			if value == "" {
+				value = OutputFormats[0]
+			}
+			for _, format := range OutputFormats {
+				if format == value {
+					config.OutputFormat = value
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown output format %s, expected one of %s", value, strings.Join(OutputFormats, ", "))
+		},
+	},
+	"nintendont_dir": {
+		get: func() string { return config.NintendontDir },
+		set: func(value string) error {
+			config.NintendontDir = value
+			return nil
+		},
+	},
+	"nintendont_format": {
+		get: func() string { return config.NintendontFormat },
+		set: func(value string) error {
+			if value == "" {
+				value = NintendontFormats[0]
+			}
+			for _, format := range NintendontFormats {
+				if format == value {
+					config.NintendontFormat = value
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown Nintendont format %s, expected one of %s", value, strings.Join(NintendontFormats, ", "))
+		},
+	},
+	"installed_versions": {
+		get: func() string { return strings.Join(installedVersions(), ", ") },
+	},
+}
+
+// Create the data directory if it doesn't already exist and load the config.
+func loadDataDir() {
+	if !exists(DATA) {
+		err := os.MkdirAll(DATA, 0755)
+		check(err)
+	}
+	loadConfig()
+}
+
+// Load the config, creating it or migrating the old data files into it if needed. It is only
+// saved when that changed it and the data directory is locked, since commands that only read the
+// config don't lock it and must not overwrite what a running update saves. They use the created
+// or migrated config without saving it.
+func loadConfig() {
+	var loaded []byte
+	// Keys missing from the file must not keep the values of an earlier load
+	config = Config{}
+	if exists(ConfigFile) {
+		err := json.Unmarshal([]byte(readFile(ConfigFile)), &config)
+		if err != nil {
+			fail(ExitFailure, "Unable to read %s: %s", ConfigFile, err.Error())
+		}
+		if config.Version > ConfigVersion {
+			fail(ExitFailure, "%s was written by a newer version of %s, please update it.", ConfigFile, ExecutableName)
+		}
+		loaded, err = json.Marshal(config)
+		check(err)
+	} else {
+		migrateDataFiles()
+	}
+	// Fill in anything missing with the defaults
+	if config.Repository == "" {
+		config.Repository = GitRepository
+	}
+	if config.ISOPath == "" {
+		config.ISOPath = GNT4ISO
+	}
+	if config.Channel == "" {
+		config.Channel = Channels[0]
+	}
+	// Version 1 only recorded the installed versions, not their ISOs
+	if config.Version < 2 {
+		migrateInstallations()
+	}
+	config.Version = ConfigVersion
+	current, err := json.Marshal(config)
+	check(err)
+	if !bytes.Equal(loaded, current) && heldLock != "" {
+		saveConfig()
+	}
+}
+
+// Move the settings of the single value files used before the config file into the config.
+// The old files are deleted once the config has been saved, which only happens while the data
+// directory is locked.
+func migrateDataFiles() {
+	legacyFiles := map[string]*string{
+		filepath.Join(DATA, "git_repository"):  &config.Repository,
+		filepath.Join(DATA, "gnt4_iso_path"):   &config.ISOPath,
+		filepath.Join(DATA, "current_version"): &config.CurrentVersion,
+	}
+	var migrated []string
+	for legacyFile, value := range legacyFiles {
+		if exists(legacyFile) {
+			*value = strings.TrimSpace(readFile(legacyFile))
+			migrated = append(migrated, legacyFile)
+		}
+	}
+	if len(migrated) == 0 {
+		return
+	}
+	if config.CurrentVersion != "" {
+		config.InstalledVersions = []Installation{{Version: config.CurrentVersion}}
+	}
+	// Like a version 1 config, only the version of the installation is known
+	config.Version = 1
+	if heldLock == "" {
+		return
+	}
+	saveConfig()
+	for _, legacyFile := range migrated {
+		os.Remove(legacyFile)
+	}
+	fmt.Printf("Migrated settings to %s\n", ConfigFile)
+}
+
+// Write the config to the config file.
+func saveConfig() {
+	data, err := json.MarshalIndent(config, "", "  ")
+	check(err)
+	err = ioutil.WriteFile(ConfigFile, data, 0644)
+	check(err)
+}
+
+// Run the config subcommand to print or change settings, e.g. "config set channel beta".
+func configCommand(args []string) {
+	flags := newFlagSet("config", commands["config"].usage)
+	// The config command is meant for scripts and terminals, so never pause at exit. This is set
+	// after the flags are defined, since defining -yes resets it.
+	argNonInteractive = true
+	usage := flags.Usage
+	flags.Usage = func() {
+		usage()
+		fmt.Println("Keys: " + strings.Join(configKeyNames(), ", "))
+	}
+	flags.Parse(args)
+	args = flags.Args()
+	selectProfile()
+	if len(args) > 0 && args[0] != "get" {
+		lockDataDir()
+	}
+	loadDataDir()
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	switch {
+	case args[0] == "get" && len(args) == 1:
+		for _, name := range configKeyNames() {
+			fmt.Printf("%s = %s\n", name, configKeys[name].get())
+		}
+	case args[0] == "get" && len(args) == 2:
+		fmt.Println(getConfigKey(args[1]).get())
+	case args[0] == "set" && len(args) == 3:
+		setConfigKey(args[1], args[2])
+	case args[0] == "unset" && len(args) == 2:
+		setConfigKey(args[1], "")
+	default:
+		flags.Usage()
+		exit(ExitUsage)
+	}
+}
+
+// Get a config key by name, exiting if there is no such key.
+func getConfigKey(name string) configKey {
+	key, ok := configKeys[name]
+	if !ok {
+		fmt.Printf("Unknown config key %s, expected one of %s\n", name, strings.Join(configKeyNames(), ", "))
+		exit(ExitUsage)
+	}
+	return key
+}
+
+// Set a config key and save the config, exiting if the key or value is invalid.
+func setConfigKey(name string, value string) {
+	key := getConfigKey(name)
+	if key.set == nil {
+		fmt.Printf("Config key %s is read-only\n", name)
+		exit(ExitUsage)
+	}
+	if err := key.set(value); err != nil {
+		fmt.Println(err.Error())
+		exit(ExitUsage)
+	}
+	saveConfig()
+}
+
+// Set an integer setting, where an empty value resets it to the default of 0.
+func setConfigInt(setting *int, value string) error {
+	if value == "" {
+		*setting = 0
+		return nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return fmt.Errorf("expected a positive number but got %s", value)
+	}
+	*setting = number
+	return nil
+}
+
+// Return the names of the config keys in alphabetical order.
+func configKeyNames() []string {
+	var names []string
+	for name := range configKeys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
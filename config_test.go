@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateDataFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	check(err)
+	defer os.RemoveAll(dir)
+	applyProfile(builtinProfiles[0])
+	setDataDir(dir)
+	defer setDataDir("data")
+	lockDataDir()
+	defer unlockDataDir()
+
+	repository := "https://api.github.com/repos/Super-GNT4/SCON4-Betas/releases"
+	check(ioutil.WriteFile(filepath.Join(dir, "git_repository"), []byte(repository), 0644))
+	check(ioutil.WriteFile(filepath.Join(dir, "gnt4_iso_path"), []byte("D:/GNT/GNT4.iso"), 0644))
+	check(ioutil.WriteFile(filepath.Join(dir, "current_version"), []byte("1.6.1"), 0644))
+	loadConfig()
+
+	if config.Repository != repository {
+		t.Fatalf("Expected repository %s but got %s", repository, config.Repository)
+	}
+	if config.ISOPath != "D:/GNT/GNT4.iso" {
+		t.Fatalf("Expected ISO path D:/GNT/GNT4.iso but got %s", config.ISOPath)
+	}
+	if config.CurrentVersion != "1.6.1" || len(config.InstalledVersions) != 1 {
+		t.Fatalf("Expected current version 1.6.1 but got %s", config.CurrentVersion)
+	}
+	if config.Channel != "stable" || config.Version != ConfigVersion {
+		t.Fatalf("Expected defaults to be filled in but got %+v", config)
+	}
+	for _, legacyFile := range []string{"git_repository", "gnt4_iso_path", "current_version"} {
+		if exists(filepath.Join(dir, legacyFile)) {
+			t.Fatalf("Expected %s to be removed after migration", legacyFile)
+		}
+	}
+
+	// Loading again should read the config file instead of migrating
+	config = Config{}
+	loadConfig()
+	if config.Repository != repository || config.CurrentVersion != "1.6.1" {
+		t.Fatalf("Config was not saved: %+v", config)
+	}
+}
+
+// Test that loading a complete config doesn't write it again, since commands that only read it
+// don't lock the data directory.
+func TestLoadConfigWithoutChanges(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	defer func() { config = Config{} }()
+	loadConfig()
+	if exists(ConfigFile) {
+		t.Fatal("Expected a new config not to be saved without the lock")
+	}
+	lockDataDir()
+	defer unlockDataDir()
+	loadConfig()
+	if !exists(ConfigFile) {
+		t.Fatal("Expected a new config to be saved")
+	}
+	saved, err := json.Marshal(config)
+	check(err)
+	check(ioutil.WriteFile(ConfigFile, saved, 0644))
+	loadConfig()
+	if reloaded := readFile(ConfigFile); reloaded != string(saved) {
+		t.Errorf("Expected the config to be left as is but got %s", reloaded)
+	}
+}
+
+// Test that keys missing from the config file don't keep the values of an earlier load.
+func TestLoadConfigResets(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	defer func() { config = Config{} }()
+	check(ioutil.WriteFile(ConfigFile, []byte(`{"version": 2, "current_version": "1.6.1"}`), 0644))
+	config = Config{Channel: "beta", OutputDir: "ISOs"}
+	loadConfig()
+	if config.Channel != "stable" || config.OutputDir != "" || config.CurrentVersion != "1.6.1" {
+		t.Errorf("Expected only the saved keys and the defaults but got %+v", config)
+	}
+}
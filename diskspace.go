@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// fat32MaxFileSize the largest file a FAT32 volume can hold
+var fat32MaxFileSize int64 = 1<<32 - 1
+
+// GameCubeDiscSize the size of a full GameCube disc image
+var GameCubeDiscSize int64 = 0x57058000
+
+// volume the free space and filesystem of the volume a path is on
+type volume struct {
+	free int64
+	// filesystem the filesystem in lowercase, e.g. "fat32", "exfat" or "ntfs", if it is known
+	filesystem string
+}
+
+// errVolumeUnsupported checking volumes isn't supported on this OS
+var errVolumeUnsupported = errors.New("checking free space isn't supported on this OS")
+
+// Get the closest existing directory of a path, since the volume of a file that doesn't exist yet
+// can only be checked through its parents.
+func existingParent(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	for {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// Fail with ExitNoSpace if a file of the given size can't be written to a path.
+func checkVolume(path string, size int64) {
+	if err := volumeProblem(path, size); err != nil {
+		fail(ExitNoSpace, "%s", err.Error())
+	}
+}
+
+// Check whether a file of the given size can be written to a path, or whether the volume is too
+// full or its filesystem can't hold a file that large. A file already at the path is replaced,
+// so only the space beyond its size is needed. Volumes that can't be checked are assumed to be
+// fine.
+func volumeProblem(path string, size int64) error {
+	v, err := statVolume(existingParent(path))
+	if err != nil {
+		return nil
+	}
+	if v.filesystem == "fat32" && size > fat32MaxFileSize {
+		return fmt.Errorf("%s is on a FAT32 volume, which can't hold the %s file. Write it as a CISO or format the volume as exFAT",
+			path, formatBytes(size))
+	}
+	needed := size
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		needed -= info.Size()
+	}
+	if v.free < needed {
+		return fmt.Errorf("Not enough free space for %s: %s is needed but only %s is free. Free up %s on that volume and try again",
+			path, formatBytes(needed), formatBytes(v.free), formatBytes(needed-v.free))
+	}
+	return nil
+}
+
+// Fail with ExitNoSpace if there isn't enough free memory for a step that holds a whole disc image
+// in memory. Memory that can't be checked is assumed to be fine.
+func checkMemory(needed int64, step string) {
+	available, err := availableMemory()
+	if err != nil || available >= needed {
+		return
+	}
+	fail(ExitNoSpace, "Not enough free memory to %s: %s is needed but only %s is available. Close other programs and try again",
+		step, formatBytes(needed), formatBytes(available))
+}
+
+// Fail early if the image a patch creates won't fit in the output directory. A compressed image
+// is written as it is patched, so only the most space it can take is needed.
+func checkOutputSpace(patchPath string, outputImage string, format string) {
+	size, err := xdeltaTargetSize(patchPath)
+	if err != nil {
+		// Patching reports an invalid patch
+		return
+	}
+	checkVolume(outputImage, maxImageSize(format, size))
+}
@@ -0,0 +1,48 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// filesystemNames the filesystems of the statfs type names that matter for writing disc images
+var filesystemNames = map[string]string{
+	"msdos": "fat32",
+	"exfat": "exfat",
+	"ntfs":  "ntfs",
+}
+
+// Get the free space and filesystem of the volume a directory is on.
+func statVolume(dir string) (volume, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return volume{}, err
+	}
+	name := make([]byte, 0, len(stat.Fstypename))
+	for _, c := range stat.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return volume{
+		free:       int64(stat.Bavail) * int64(stat.Bsize),
+		filesystem: filesystemNames[string(name)],
+	}, nil
+}
+
+// Get the memory that can be used. macOS compresses and swaps memory rather than reporting what
+// is free, so this is the physical memory of the Mac.
+func availableMemory() (int64, error) {
+	value, err := syscall.Sysctl("hw.memsize")
+	if err != nil {
+		return 0, err
+	}
+	// The trailing zero bytes of the 64 bit value are cut off
+	data := make([]byte, 8)
+	copy(data, value)
+	return int64(binary.LittleEndian.Uint64(data)), nil
+}
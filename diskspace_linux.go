@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// filesystemMagics the filesystems of the statfs types that matter for writing disc images
+var filesystemMagics = map[int64]string{
+	0x4d44:     "fat32",
+	0x2011bab0: "exfat",
+	0x5346544e: "ntfs",
+}
+
+// Get the free space and filesystem of the volume a directory is on.
+func statVolume(dir string) (volume, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return volume{}, err
+	}
+	return volume{
+		free:       int64(stat.Bavail) * int64(stat.Bsize),
+		filesystem: filesystemMagics[int64(stat.Type)],
+	}, nil
+}
+
+// Get the memory available to start a program with, from MemAvailable in /proc/meminfo.
+func availableMemory() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, errors.New("MemAvailable not found in /proc/meminfo")
+}
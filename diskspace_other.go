@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+// Get the free space and filesystem of the volume a directory is on.
+func statVolume(dir string) (volume, error) {
+	return volume{}, errVolumeUnsupported
+}
+
+// Get the memory that is available.
+func availableMemory() (int64, error) {
+	return 0, errVolumeUnsupported
+}
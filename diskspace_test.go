@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Test that the volume of a file that doesn't exist yet is checked through its parents.
+func TestStatVolume(t *testing.T) {
+	dir := t.TempDir()
+	if parent := existingParent(filepath.Join(dir, "games", "SCON4 [G4NJDA]", "game.iso")); parent != dir {
+		t.Errorf("Expected %s but got %s", dir, parent)
+	}
+	v, err := statVolume(dir)
+	if err == errVolumeUnsupported {
+		t.Skip(err.Error())
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if v.free <= 0 {
+		t.Errorf("Expected free space but got %d", v.free)
+	}
+}
+
+// Test that the available memory can be read.
+func TestAvailableMemory(t *testing.T) {
+	available, err := availableMemory()
+	if err == errVolumeUnsupported {
+		t.Skip(err.Error())
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if available <= 0 {
+		t.Errorf("Expected available memory but got %d", available)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                  = syscall.NewLazyDLL("kernel32.dll")
+	procGetDiskFreeSpaceExW   = kernel32.NewProc("GetDiskFreeSpaceExW")
+	procGetVolumePathNameW    = kernel32.NewProc("GetVolumePathNameW")
+	procGetVolumeInformationW = kernel32.NewProc("GetVolumeInformationW")
+	procGlobalMemoryStatusEx  = kernel32.NewProc("GlobalMemoryStatusEx")
+)
+
+// memoryStatusEx the MEMORYSTATUSEX of GlobalMemoryStatusEx
+type memoryStatusEx struct {
+	length               uint32
+	memoryLoad           uint32
+	totalPhys            uint64
+	availPhys            uint64
+	totalPageFile        uint64
+	availPageFile        uint64
+	totalVirtual         uint64
+	availVirtual         uint64
+	availExtendedVirtual uint64
+}
+
+// Get the free space and filesystem of the volume a directory is on.
+func statVolume(dir string) (volume, error) {
+	dirPtr, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return volume{}, err
+	}
+	var free uint64
+	ok, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(dirPtr)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if ok == 0 {
+		return volume{}, err
+	}
+	v := volume{free: int64(free)}
+	// The filesystem can only be asked for by the root of the volume
+	root := make([]uint16, syscall.MAX_PATH+1)
+	ok, _, _ = procGetVolumePathNameW.Call(uintptr(unsafe.Pointer(dirPtr)), uintptr(unsafe.Pointer(&root[0])), uintptr(len(root)))
+	if ok == 0 {
+		return v, nil
+	}
+	name := make([]uint16, syscall.MAX_PATH+1)
+	ok, _, _ = procGetVolumeInformationW.Call(uintptr(unsafe.Pointer(&root[0])), 0, 0, 0, 0, 0,
+		uintptr(unsafe.Pointer(&name[0])), uintptr(len(name)))
+	if ok != 0 {
+		v.filesystem = strings.ToLower(syscall.UTF16ToString(name))
+	}
+	return v, nil
+}
+
+// Get the physical memory that is available.
+func availableMemory() (int64, error) {
+	status := memoryStatusEx{}
+	status.length = uint32(unsafe.Sizeof(status))
+	ok, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status)))
+	if ok == 0 {
+		return 0, err
+	}
+	return int64(status.availPhys), nil
+}
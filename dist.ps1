@@ -1,27 +1,48 @@
-$VERSION = "2.0.0"
-
-# Recreate dist directory
-Remove-Item -Force -Recurse -Path dist -ErrorAction Ignore
-New-Item -ItemType Directory -Force -Path dist
-
-# Generate Windows binary
-$Env:GOOS = "windows"; $Env:GOARCH = "amd64"
-go generate
-garble build
-
-# Zip Windows binary
-tar.exe -acf "dist/Six-Patches-Of-Pain-$VERSION-Windows.zip" Six-Patches-Of-Pain.exe
-
-# Generate Mac binary
-$Env:GOOS = "darwin"; $Env:GOARCH = "amd64"
-go build
-
-# Zip Mac binary
-tar.exe -acf "dist/Six-Patches-Of-Pain-$VERSION-Mac.zip" Six-Patches-Of-Pain
-
-# Generate Linux binary
-$Env:GOOS = "linux"; $Env:GOARCH = "amd64"
-go build
-
-# Zip Linux binary
-tar.exe -acf "dist/Six-Patches-Of-Pain-$VERSION-Linux.zip" Six-Patches-Of-Pain
+$VERSION = "2.0.0"
+
+# The minisign public key self-updates are verified with, and the secret key the release is signed with
+$UPDATER_PUBLIC_KEY = $Env:UPDATER_PUBLIC_KEY
+$MINISIGN_SECRET_KEY = $Env:MINISIGN_SECRET_KEY
+if (-not $UPDATER_PUBLIC_KEY -or -not $MINISIGN_SECRET_KEY) {
+    Write-Error "Set UPDATER_PUBLIC_KEY and MINISIGN_SECRET_KEY, without them self-updates can't be verified"
+    exit 1
+}
+$LDFLAGS = "-X main.UpdaterPublicKey=$UPDATER_PUBLIC_KEY"
+
+# Recreate dist directory
+Remove-Item -Force -Recurse -Path dist -ErrorAction Ignore
+New-Item -ItemType Directory -Force -Path dist
+
+# Generate Windows binary
+$Env:GOOS = "windows"; $Env:GOARCH = "amd64"
+go generate
+garble build -ldflags "$LDFLAGS"
+
+# Zip Windows binary
+tar.exe -acf "dist/Six-Patches-Of-Pain-$VERSION-Windows.zip" Six-Patches-Of-Pain.exe
+
+# Generate Mac binary
+$Env:GOOS = "darwin"; $Env:GOARCH = "amd64"
+go build -ldflags "$LDFLAGS"
+
+# Zip Mac binary
+tar.exe -acf "dist/Six-Patches-Of-Pain-$VERSION-Mac.zip" Six-Patches-Of-Pain
+
+# Generate Linux binary
+$Env:GOOS = "linux"; $Env:GOARCH = "amd64"
+go build -ldflags "$LDFLAGS"
+
+# Zip Linux binary
+tar.exe -acf "dist/Six-Patches-Of-Pain-$VERSION-Linux.zip" Six-Patches-Of-Pain
+
+# Write the checksums of the zips in the format of sha256sum and sign them, both are uploaded
+# with the release
+$sums = Get-ChildItem dist -Filter *.zip | ForEach-Object {
+    "$((Get-FileHash -Algorithm SHA256 $_.FullName).Hash.ToLower())  $($_.Name)"
+}
+[IO.File]::WriteAllText("$PWD/dist/SHA256SUMS", ($sums -join "`n") + "`n")
+minisign -S -s $MINISIGN_SECRET_KEY -m dist/SHA256SUMS -x dist/SHA256SUMS.minisig
+if ($LASTEXITCODE -ne 0) {
+    Write-Error "Unable to sign SHA256SUMS"
+    exit 1
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// DolphinModes how patched ISOs are added to Dolphin: not at all, by adding their folder to the
+// game list, or by also making the active version the default ISO
+var DolphinModes = []string{"off", "register", "default"}
+
+// DolphinUserPathEnv environment variable Dolphin reads its user directory from
+var DolphinUserPathEnv = "DOLPHIN_EMU_USERPATH"
+
+// Find the Dolphin config directory holding Dolphin.ini, checking in the order Dolphin does:
+// a portable install, the user directory set in the environment and the default per OS.
+// dolphinDir is a Dolphin install with a portable.txt or a Dolphin user directory.
+func findDolphinConfigDir(dolphinDir string, home string, goos string, getenv func(string) string) (string, bool) {
+	var candidates []string
+	if dolphinDir != "" {
+		if exists(filepath.Join(dolphinDir, "portable.txt")) {
+			candidates = append(candidates, filepath.Join(dolphinDir, "User", "Config"))
+		}
+		candidates = append(candidates, filepath.Join(dolphinDir, "Config"))
+	}
+	if userPath := getenv(DolphinUserPathEnv); userPath != "" {
+		candidates = append(candidates, filepath.Join(userPath, "Config"))
+	}
+	// On Linux the config is in the XDG config home, even if only the data directory exists yet
+	var xdgConfigDir string
+	switch goos {
+	case "windows":
+		candidates = append(candidates, filepath.Join(home, "Documents", "Dolphin Emulator", "Config"))
+	case "darwin":
+		candidates = append(candidates, filepath.Join(home, "Library", "Application Support", "Dolphin", "Config"))
+	default:
+		// Before XDG directories, everything was in ~/.dolphin-emu
+		candidates = append(candidates, filepath.Join(home, ".dolphin-emu", "Config"))
+		configHome := getenv("XDG_CONFIG_HOME")
+		if configHome == "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		dataHome := getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		candidates = append(candidates, filepath.Join(configHome, "dolphin-emu"))
+		if exists(filepath.Join(dataHome, "dolphin-emu")) {
+			xdgConfigDir = filepath.Join(configHome, "dolphin-emu")
+		}
+	}
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return candidate, true
+		}
+	}
+	return xdgConfigDir, xdgConfigDir != ""
+}
+
+// Get the path of Dolphin.ini, or false if Dolphin can't be found.
+func dolphinIniPath() (string, bool) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	configDir, found := findDolphinConfigDir(config.DolphinDir, home, runtime.GOOS, os.Getenv)
+	if !found {
+		return "", false
+	}
+	return filepath.Join(configDir, "Dolphin.ini"), true
+}
+
+// Get the Dolphin user directory holding GameSettings and Load for a config directory. With XDG
+// directories on Linux it is in the XDG data home instead.
+func findDolphinUserDir(configDir string, home string, getenv func(string) string) string {
+	if filepath.Base(configDir) == "Config" {
+		return filepath.Dir(configDir)
+	}
+	dataHome := getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "dolphin-emu")
+}
+
+// Get the Dolphin user directory, or false if Dolphin can't be found.
+func dolphinUserDir() (string, bool) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+	configDir, found := findDolphinConfigDir(config.DolphinDir, home, runtime.GOOS, os.Getenv)
+	if !found {
+		return "", false
+	}
+	return findDolphinUserDir(configDir, home, os.Getenv), true
+}
+
+// Get the path of the Dolphin.ini of a Dolphin user directory. With XDG directories on Linux it
+// isn't in the user directory but in the XDG config home.
+func userDolphinIni(userDir string) string {
+	if iniPath, found := dolphinIniPath(); found {
+		if dir, found := dolphinUserDir(); found && dir == userDir {
+			return iniPath
+		}
+	}
+	return filepath.Join(userDir, "Config", "Dolphin.ini")
+}
+
+// Add the folder of a patched ISO to the Dolphin game list, and make it the default ISO if
+// configured. If Dolphin.ini can't be found or written, the folder can still be added in Dolphin
+// by hand, so the error is printed instead of failing the update.
+func registerWithDolphin(isoPath string) {
+	if config.Dolphin == "" || config.Dolphin == "off" {
+		return
+	}
+	iniPath, found := dolphinIniPath()
+	if !found {
+		fmt.Println("Unable to find the Dolphin user directory, set it with: config set dolphin_dir <directory>")
+		return
+	}
+	err := updateDolphinIni(iniPath, func(ini *iniFile) {
+		absPath, err := filepath.Abs(isoPath)
+		check(err)
+		paths := dolphinISOPaths(ini)
+		if !containsPath(paths, filepath.Dir(absPath)) {
+			setDolphinISOPaths(ini, append(paths, filepath.Dir(absPath)))
+		}
+		if config.Dolphin == "default" {
+			ini.set("Core", "DefaultISO", absPath)
+		}
+	})
+	if err != nil {
+		fmt.Printf("Unable to add %s to Dolphin: %s\n", isoPath, err.Error())
+		return
+	}
+	fmt.Printf("Added %s to Dolphin\n", isoPath)
+}
+
+// Remove a deleted ISO from Dolphin: the default ISO is cleared if it was this ISO, and its
+// folder is removed from the game list when no other installed version is in it.
+func unregisterFromDolphin(isoPath string) {
+	if config.Dolphin == "" || config.Dolphin == "off" || isoPath == "" {
+		return
+	}
+	iniPath, found := dolphinIniPath()
+	if !found || !exists(iniPath) {
+		return
+	}
+	err := updateDolphinIni(iniPath, func(ini *iniFile) {
+		if defaultISO, ok := ini.get("Core", "DefaultISO"); ok && samePath(defaultISO, isoPath) {
+			ini.set("Core", "DefaultISO", "")
+		}
+		dir := filepath.Dir(isoPath)
+		for _, installation := range config.InstalledVersions {
+			if installation.Path != isoPath && samePath(filepath.Dir(installation.Path), dir) {
+				return
+			}
+		}
+		var paths []string
+		for _, path := range dolphinISOPaths(ini) {
+			if !samePath(path, dir) {
+				paths = append(paths, path)
+			}
+		}
+		setDolphinISOPaths(ini, paths)
+	})
+	if err != nil {
+		fmt.Printf("Unable to remove %s from Dolphin: %s\n", isoPath, err.Error())
+	}
+}
+
+// Read Dolphin.ini, change it and write it back. A missing Dolphin.ini is created.
+func updateDolphinIni(iniPath string, change func(ini *iniFile)) error {
+	ini, err := readIni(iniPath)
+	if err != nil {
+		return err
+	}
+	change(ini)
+	return ini.write(iniPath)
+}
+
+// Get the folders of the Dolphin game list.
+func dolphinISOPaths(ini *iniFile) []string {
+	value, _ := ini.get("General", "ISOPaths")
+	count, _ := strconv.Atoi(value)
+	var paths []string
+	for i := 0; i < count; i++ {
+		if path, ok := ini.get("General", "ISOPath"+strconv.Itoa(i)); ok && path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Set the folders of the Dolphin game list, renumbering them.
+func setDolphinISOPaths(ini *iniFile, paths []string) {
+	value, _ := ini.get("General", "ISOPaths")
+	count, _ := strconv.Atoi(value)
+	for i := 0; i < count; i++ {
+		ini.remove("General", "ISOPath"+strconv.Itoa(i))
+	}
+	ini.set("General", "ISOPaths", strconv.Itoa(len(paths)))
+	for i, path := range paths {
+		ini.set("General", "ISOPath"+strconv.Itoa(i), path)
+	}
+}
+
+func containsPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if samePath(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare paths the way Dolphin may have written them, with either kind of slash.
+func samePath(a string, b string) bool {
+	a = filepath.Clean(filepath.FromSlash(a))
+	b = filepath.Clean(filepath.FromSlash(b))
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// iniFile an ini file edited in place, keeping the lines it doesn't change as they are
+type iniFile struct {
+	lines []string
+}
+
+// Read an ini file, or an empty one if it doesn't exist.
+func readIni(filePath string) (*iniFile, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return &iniFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	return &iniFile{lines: strings.Split(strings.TrimSuffix(text, "\n"), "\n")}, nil
+}
+
+// Get the range of lines of a section after its header, or -1 if it doesn't exist.
+func (f *iniFile) section(name string) (int, int) {
+	start := -1
+	for i, line := range f.lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			if start >= 0 {
+				return start, i
+			}
+			if line == "["+name+"]" {
+				start = i + 1
+			}
+		}
+	}
+	if start < 0 {
+		return -1, -1
+	}
+	return start, len(f.lines)
+}
+
+// Get the index of the line of a key in a section, or -1 if it isn't set.
+func (f *iniFile) find(section string, key string) int {
+	start, end := f.section(section)
+	for i := start; i >= 0 && i < end; i++ {
+		parts := strings.SplitN(f.lines[i], "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *iniFile) get(section string, key string) (string, bool) {
+	i := f.find(section, key)
+	if i < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(strings.SplitN(f.lines[i], "=", 2)[1]), true
+}
+
+// Set a key, adding it to the end of its section and adding the section if needed.
+func (f *iniFile) set(section string, key string, value string) {
+	line := key + " = " + value
+	if i := f.find(section, key); i >= 0 {
+		f.lines[i] = line
+		return
+	}
+	start, end := f.section(section)
+	if start < 0 {
+		f.lines = append(f.lines, "["+section+"]", line)
+		return
+	}
+	// Keep blank lines between sections after the new key
+	for end > start && strings.TrimSpace(f.lines[end-1]) == "" {
+		end--
+	}
+	f.lines = append(f.lines[:end], append([]string{line}, f.lines[end:]...)...)
+}
+
+func (f *iniFile) remove(section string, key string) {
+	if i := f.find(section, key); i >= 0 {
+		f.lines = append(f.lines[:i], f.lines[i+1:]...)
+	}
+}
+
+func (f *iniFile) write(filePath string) error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, []byte(strings.Join(f.lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that the Dolphin config directory is found for portable, environment and XDG installs.
+func TestFindDolphinConfigDir(t *testing.T) {
+	home := t.TempDir()
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+
+	if _, found := findDolphinConfigDir("", home, "linux", getenv); found {
+		t.Fatal("Expected no Dolphin to be found in an empty home")
+	}
+	os.MkdirAll(filepath.Join(home, ".local", "share", "dolphin-emu"), 0755)
+	if dir, _ := findDolphinConfigDir("", home, "linux", getenv); dir != filepath.Join(home, ".config", "dolphin-emu") {
+		t.Errorf("Expected the XDG config directory but got %s", dir)
+	}
+	os.MkdirAll(filepath.Join(home, "Documents", "Dolphin Emulator", "Config"), 0755)
+	if dir, _ := findDolphinConfigDir("", home, "windows", getenv); dir != filepath.Join(home, "Documents", "Dolphin Emulator", "Config") {
+		t.Errorf("Expected the Documents directory but got %s", dir)
+	}
+	env[DolphinUserPathEnv] = filepath.Join(home, "custom")
+	os.MkdirAll(filepath.Join(home, "custom", "Config"), 0755)
+	if dir, _ := findDolphinConfigDir("", home, "windows", getenv); dir != filepath.Join(home, "custom", "Config") {
+		t.Errorf("Expected the user directory of the environment but got %s", dir)
+	}
+	portable := filepath.Join(home, "Dolphin-x64")
+	os.MkdirAll(filepath.Join(portable, "User", "Config"), 0755)
+	os.WriteFile(filepath.Join(portable, "portable.txt"), nil, 0644)
+	if dir, _ := findDolphinConfigDir(portable, home, "windows", getenv); dir != filepath.Join(portable, "User", "Config") {
+		t.Errorf("Expected the portable user directory but got %s", dir)
+	}
+}
+
+// Test that patched ISOs are added to and removed from the game list of a fake Dolphin.
+func TestRegisterWithDolphin(t *testing.T) {
+	dolphinDir := t.TempDir()
+	os.WriteFile(filepath.Join(dolphinDir, "portable.txt"), nil, 0644)
+	configDir := filepath.Join(dolphinDir, "User", "Config")
+	os.MkdirAll(configDir, 0755)
+	iniPath := filepath.Join(configDir, "Dolphin.ini")
+	os.WriteFile(iniPath, []byte("[General]\r\nISOPaths = 1\r\nISOPath0 = /games/other\r\n\r\n[Core]\r\nCPUThread = True\r\n"), 0644)
+	defer func() { config = Config{} }()
+	isoDir := t.TempDir()
+	isoPath := filepath.Join(isoDir, "SCON4-v1.2.0.iso")
+	config = Config{Dolphin: "default", DolphinDir: dolphinDir}
+
+	registerWithDolphin(isoPath)
+	registerWithDolphin(isoPath)
+	ini, err := readIni(iniPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := dolphinISOPaths(ini)
+	if len(paths) != 2 || paths[0] != "/games/other" || paths[1] != isoDir {
+		t.Errorf("Expected the ISO folder to be added once but got %v", paths)
+	}
+	if defaultISO, _ := ini.get("Core", "DefaultISO"); defaultISO != isoPath {
+		t.Errorf("Expected the default ISO to be %s but got %s", isoPath, defaultISO)
+	}
+	if cpuThread, _ := ini.get("Core", "CPUThread"); cpuThread != "True" {
+		t.Error("Expected the other settings to be kept")
+	}
+
+	unregisterFromDolphin(isoPath)
+	ini, err = readIni(iniPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths = dolphinISOPaths(ini)
+	if len(paths) != 1 || paths[0] != "/games/other" {
+		t.Errorf("Expected the ISO folder to be removed but got %v", paths)
+	}
+	if defaultISO, _ := ini.get("Core", "DefaultISO"); defaultISO != "" {
+		t.Errorf("Expected the default ISO to be cleared but got %s", defaultISO)
+	}
+	data, _ := os.ReadFile(iniPath)
+	if !strings.Contains(string(data), "ISOPaths = 1\nISOPath0 = /games/other\n\n[Core]") {
+		t.Errorf("Unexpected Dolphin.ini:\n%s", data)
+	}
+}
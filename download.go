@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetries default number of times a failed download is retried
+const DefaultRetries = 5
+
+// DefaultTimeout default number of seconds without any data after which a download is retried
+const DefaultTimeout = 30
+
+// argRetries number of times a failed download is retried, given as argument
+var argRetries optionalInt
+
+// argTimeout seconds without any data after which a download is retried, given as argument
+var argTimeout int
+
+// maxBackoff the longest time to wait between two attempts of a download
+var maxBackoff = 30 * time.Second
+
+// httpStatusError a response with an unexpected status code
+type httpStatusError struct {
+	status string
+	code   int
+}
+
+func (e *httpStatusError) Error() string {
+	return "Unable to download file, status: " + e.status
+}
+
+// permanentError an error that trying again won't fix, such as an invalid url
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// optionalInt a number given as argument that tells apart not being given from being given as 0
+type optionalInt struct {
+	value int
+	set   bool
+}
+
+func (o *optionalInt) String() string {
+	if o == nil || !o.set {
+		return ""
+	}
+	return strconv.Itoa(o.value)
+}
+
+func (o *optionalInt) Set(value string) error {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return fmt.Errorf("expected 0 or a positive number but got %s", value)
+	}
+	o.value, o.set = number, true
+	return nil
+}
+
+// Download to a file path the file at the given url. The file is first downloaded to a .part
+// file, which is resumed when the download fails and is retried. If the expected size is
+// known it is compared to the size of the downloaded file. file:// urls are copied instead.
+func download(url string, filePath string, expectedSize int64) error {
+	partPath := filePath + ".part"
+	if expectedSize > 0 {
+		checkVolume(partPath, expectedSize)
+	}
+	var err error
+	if strings.HasPrefix(url, "file:") {
+		err = copyLocal(url, partPath)
+	} else {
+		err = downloadWithRetries(url, partPath)
+	}
+	if err != nil {
+		return err
+	}
+	size := getFileSize(partPath)
+	if expectedSize > 0 && size != expectedSize {
+		removePart(partPath)
+		return fmt.Errorf("downloaded %d bytes but expected %d bytes", size, expectedSize)
+	}
+	removePartValidator(partPath)
+	os.Remove(filePath)
+	return os.Rename(partPath, filePath)
+}
+
+// Download a url to a .part file, retrying and resuming it when the download fails.
+func downloadWithRetries(url string, partPath string) error {
+	retries, timeout := downloadSettings()
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			delay := backoff(attempt)
+			fmt.Printf("Download failed: %s\nRetrying in %s (%d/%d)...\n", err.Error(), delay, attempt, retries)
+			time.Sleep(delay)
+		}
+		err = downloadPart(url, partPath, timeout)
+		if err == nil || !isRetryable(err) {
+			break
+		}
+	}
+	return err
+}
+
+// Get the number of retries and the timeout, preferring arguments over the config. Retries
+// are turned off with 0.
+func downloadSettings() (int, time.Duration) {
+	retries := DefaultRetries
+	if config.Retries != nil {
+		retries = *config.Retries
+	}
+	if argRetries.set {
+		retries = argRetries.value
+	}
+	timeout := config.Timeout
+	if argTimeout > 0 {
+		timeout = argTimeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return retries, time.Duration(timeout) * time.Second
+}
+
+// Make one attempt at downloading the rest of a .part file. The part is only resumed if
+// the server confirms with If-Range that the file has not changed since it was started.
+func downloadPart(url string, partPath string, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return &permanentError{err: fmt.Errorf("unsupported protocol scheme %q of %s", req.URL.Scheme, url)}
+	}
+	var offset int64
+	validator := readPartValidator(partPath, url)
+	if exists(partPath) && validator != "" {
+		offset = getFileSize(partPath)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", validator)
+	}
+	// Cancel the request if no response or data arrives in time
+	timer := time.AfterFunc(timeout, cancel)
+	defer timer.Stop()
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("no response received for %s", timeout)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			removePart(partPath)
+			return fmt.Errorf("server resumed at the wrong offset: %s", resp.Header.Get("Content-Range"))
+		}
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// The server sent the whole file, either because nothing was downloaded yet or because
+		// the file changed since the part was downloaded
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The part may already be complete, otherwise start over
+		if total := contentRangeTotal(resp.Header.Get("Content-Range")); total > 0 && total == offset {
+			return nil
+		}
+		removePart(partPath)
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode}
+	default:
+		return &httpStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	writePartValidator(partPath, url, resp)
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	bar := startProgress("download-progress", total)
+	defer bar.Finish()
+	bar.SetCurrent(offset)
+	body := &timeoutReader{reader: bar.NewProxyReader(resp.Body), timer: timer, timeout: timeout}
+	_, err = io.Copy(out, body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("no data received for %s", timeout)
+		}
+		return err
+	}
+	if total >= 0 && getFileSize(partPath) != total {
+		return fmt.Errorf("downloaded %d bytes but the server sent a length of %d bytes", getFileSize(partPath), total)
+	}
+	return nil
+}
+
+// Copy a file:// url to a .part file, so that local releases are handled like downloads.
+func copyLocal(fileURL string, partPath string) error {
+	sourcePath, err := localFile(fileURL)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	removePart(partPath)
+	out, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bar := startProgress("download-progress", info.Size())
+	defer bar.Finish()
+	_, err = io.Copy(out, bar.NewProxyReader(in))
+	return err
+}
+
+// Return whether a failed download should be tried again.
+func isRetryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.code
+		return code >= 500 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout ||
+			code == http.StatusRequestedRangeNotSatisfiable
+	}
+	return true
+}
+
+// Get the time to wait before an attempt, doubling with every attempt.
+func backoff(attempt int) time.Duration {
+	delay := time.Second << uint(attempt-1)
+	if delay > maxBackoff || delay <= 0 {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// Get the total size from a Content-Range header such as "bytes */1234".
+func contentRangeTotal(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// Save the validator used with If-Range to resume a part: a strong ETag or else Last-Modified.
+// It is saved along with the url so that a part is never resumed from a different url.
+func writePartValidator(partPath string, url string, resp *http.Response) {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator == "" {
+		removePartValidator(partPath)
+		return
+	}
+	err := ioutil.WriteFile(partPath+".etag", []byte(url+"\n"+validator), 0644)
+	check(err)
+}
+
+func readPartValidator(partPath string, url string) string {
+	if !exists(partPath + ".etag") {
+		return ""
+	}
+	lines := strings.SplitN(readFile(partPath+".etag"), "\n", 2)
+	if len(lines) != 2 || lines[0] != url {
+		return ""
+	}
+	return lines[1]
+}
+
+func removePartValidator(partPath string) {
+	os.Remove(partPath + ".etag")
+}
+
+// Delete a part and its validator so the next attempt starts over.
+func removePart(partPath string) {
+	os.Remove(partPath)
+	removePartValidator(partPath)
+}
+
+// timeoutReader restarts a timer every time data is read, so that a stalled download is
+// cancelled without limiting how long the whole download may take
+type timeoutReader struct {
+	reader  io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *timeoutReader) Read(data []byte) (int, error) {
+	n, err := r.reader.Read(data)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
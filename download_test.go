@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadResumesAfterDroppedConnection(t *testing.T) {
+	content := bytes.Repeat([]byte("Six Patches of Pain "), 5000)
+	requests := 0
+	var resumedRange string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"patch-v1"`)
+		if requests == 1 {
+			// Send half of the file, then drop the connection
+			w.Header().Set("Content-Length", "100000")
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		resumedRange = r.Header.Get("Range")
+		http.ServeContent(w, r, "patch.xdelta", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	check(err)
+	defer os.RemoveAll(dir)
+	maxBackoff = time.Millisecond
+	defer func() { maxBackoff = 30 * time.Second }()
+
+	filePath := filepath.Join(dir, "patch.xdelta")
+	err = download(server.URL, filePath, int64(len(content)))
+	if err != nil {
+		t.Fatalf("Download failed: %s", err.Error())
+	}
+	if requests != 2 {
+		t.Fatalf("Expected 2 requests but got %d", requests)
+	}
+	if resumedRange != "bytes=50000-" {
+		t.Fatalf("Expected the download to resume at byte 50000 but got range %s", resumedRange)
+	}
+	downloaded, err := ioutil.ReadFile(filePath)
+	check(err)
+	if !bytes.Equal(downloaded, content) {
+		t.Fatal("Downloaded file does not match")
+	}
+	if exists(filePath+".part") || exists(filePath+".part.etag") {
+		t.Fatal("Expected the part files to be removed")
+	}
+}
+
+func TestDownloadRejectsWrongSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("truncated"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "download")
+	check(err)
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "patch.xdelta")
+	err = download(server.URL, filePath, 1000)
+	if err == nil {
+		t.Fatal("Expected the download to fail when the size does not match the asset size")
+	}
+	if exists(filePath) || exists(filePath+".part") {
+		t.Fatal("Expected no file to be left behind")
+	}
+}
+
+// Test that an invalid url fails at once instead of being retried.
+func TestDownloadInvalidURLIsNotRetried(t *testing.T) {
+	dir := t.TempDir()
+	for _, url := range []string{"C:\\Games\\GNT4.iso", "ftp://example.com/GNT4.iso", "http://[::1"} {
+		start := time.Now()
+		err := downloadWithRetries(url, filepath.Join(dir, "GNT4.iso.part"))
+		if err == nil || isRetryable(err) {
+			t.Errorf("Expected a permanent error for %s but got %v", url, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("Expected %s to fail without retrying", url)
+		}
+	}
+}
+
+// Test that retries can be turned off with 0, and that they default to DefaultRetries.
+func TestDownloadSettingsRetries(t *testing.T) {
+	defer func() { config = Config{}; argRetries = optionalInt{} }()
+	config = Config{}
+	if retries, _ := downloadSettings(); retries != DefaultRetries {
+		t.Errorf("Expected %d retries by default but got %d", DefaultRetries, retries)
+	}
+	check(configKeys["retries"].set("0"))
+	if retries, _ := downloadSettings(); retries != 0 {
+		t.Errorf("Expected retries to be turned off in the config but got %d", retries)
+	}
+	check(argRetries.Set("3"))
+	if retries, _ := downloadSettings(); retries != 3 {
+		t.Errorf("Expected the argument to be preferred but got %d", retries)
+	}
+}
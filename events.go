@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// argJSON boolean that specifies to write newline-delimited JSON events instead of text
+var argJSON bool
+
+// eventOutput where JSON events are written, nil when JSON events are disabled
+var eventOutput io.Writer
+
+// progressInterval the minimum time between two progress events of the same step
+var progressInterval = 100 * time.Millisecond
+
+// Event a JSON event for programs wrapping the updater, such as a GUI launcher
+type Event struct {
+	Event   string `json:"event"`
+	Version string `json:"version,omitempty"`
+	Profile string `json:"profile,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+	Notes   string `json:"notes,omitempty"`
+}
+
+// Write JSON events to stdout. Text output is moved to stderr so that stdout only has events,
+// and input is disabled since a program wrapping the updater can't answer prompts.
+func enableJSONEvents() {
+	eventOutput = os.Stdout
+	os.Stdout = os.Stderr
+	argNonInteractive = true
+}
+
+// Write an event if JSON events are enabled.
+func emit(event Event) {
+	if eventOutput == nil {
+		return
+	}
+	data, err := json.Marshal(event)
+	check(err)
+	eventOutput.Write(append(data, '\n'))
+}
+
+// progress reports the progress of a long running step, either as a progress bar or as
+// progress events when JSON events are enabled
+type progress struct {
+	event     string
+	total     int64
+	current   int64
+	lastEvent time.Time
+	bar       *pb.ProgressBar
+}
+
+// Start reporting the progress of a step with the given event name and total bytes.
+func startProgress(event string, total int64) *progress {
+	p := &progress{event: event, total: total}
+	if eventOutput != nil {
+		p.emit()
+		return p
+	}
+	p.bar = pb.Full.Start64(total)
+	p.bar.Set(pb.Bytes, true)
+	p.bar.Set(pb.SIBytesPrefix, true)
+	return p
+}
+
+// Set the number of bytes processed so far.
+func (p *progress) SetCurrent(current int64) {
+	p.current = current
+	if p.bar != nil {
+		p.bar.SetCurrent(current)
+	} else if time.Since(p.lastEvent) >= progressInterval {
+		p.emit()
+	}
+}
+
+// Add to the number of bytes processed so far.
+func (p *progress) Add(n int64) {
+	p.SetCurrent(p.current + n)
+}
+
+// Wrap a reader so that reading from it reports progress.
+func (p *progress) NewProxyReader(r io.Reader) io.Reader {
+	return &progressReader{reader: r, progress: p}
+}
+
+// Finish reporting progress, writing a final event for the bytes processed.
+func (p *progress) Finish() {
+	if p.bar != nil {
+		p.bar.Finish()
+	} else {
+		p.emit()
+	}
+}
+
+func (p *progress) emit() {
+	p.lastEvent = time.Now()
+	emit(Event{Event: p.event, Bytes: p.current, Total: p.total})
+}
+
+type progressReader struct {
+	reader   io.Reader
+	progress *progress
+}
+
+func (r *progressReader) Read(data []byte) (int, error) {
+	n, err := r.reader.Read(data)
+	r.progress.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DolphinExtraFolders the folders of a patches.zip that are installed into the Dolphin user
+// directory: game settings, which also hold Gecko and Action Replay codes, and custom textures.
+// Only the files of the game itself are installed from them.
+var DolphinExtraFolders = []string{"GameSettings/", "Load/Textures/"}
+
+// BackupSuffix the suffix of a file of the user that was replaced by an installed file
+var BackupSuffix = ".bak"
+
+// Install the game settings, codes and textures of a patches.zip into the Dolphin user directory,
+// replacing the ones of the previous version and removing the ones it no longer has. A release
+// without a patches.zip removes them all. The game runs without these files too, so a zip or
+// user directory that can't be read is printed and the update carries on.
+func installDolphinExtras(zipPath string) {
+	if config.Dolphin == "" || config.Dolphin == "off" {
+		return
+	}
+	var files []*zip.File
+	if exists(zipPath) {
+		zipListing, err := zip.OpenReader(zipPath)
+		if err != nil {
+			fmt.Printf("Unable to read %s: %s\n", zipPath, err.Error())
+			return
+		}
+		defer zipListing.Close()
+		files = zipListing.File
+	}
+	if len(files) == 0 && len(config.DolphinFiles) == 0 {
+		return
+	}
+	userDir, found := dolphinUserDir()
+	if !found {
+		fmt.Println("Unable to find the Dolphin user directory, set it with: config set dolphin_dir <directory>")
+		return
+	}
+	installed, err := syncDolphinExtras(userDir, profile.GameID, files, config.DolphinFiles)
+	config.DolphinFiles = installed
+	saveConfig()
+	if err != nil {
+		fmt.Printf("Unable to install the Dolphin files: %s\n", err.Error())
+		return
+	}
+	if len(installed) > 0 {
+		fmt.Printf("Installed %d file(s) into %s\n", len(installed), userDir)
+	}
+}
+
+// Install the extra files of a game from a zip into the Dolphin user directory and remove the previously
+// installed files missing from it. Files of the user are backed up before being replaced and
+// restored when the installed file is removed; installed files changed since are left alone.
+// Returns the installed files by their path relative to the user directory with their CRC32.
+func syncDolphinExtras(userDir string, gameID string, files []*zip.File, previous map[string]string) (map[string]string, error) {
+	installed := map[string]string{}
+	// Keep track of the previous files not handled yet if something fails
+	keepPrevious := func() map[string]string {
+		for name, hash := range previous {
+			if _, ok := installed[name]; !ok {
+				installed[name] = hash
+			}
+		}
+		return installed
+	}
+	for _, f := range files {
+		name, ok := extraPath(f.Name, gameID)
+		if !ok || f.FileInfo().IsDir() {
+			continue
+		}
+		target := filepath.Join(userDir, filepath.FromSlash(name))
+		if _, ours := previous[name]; !ours && exists(target) && !exists(target+BackupSuffix) {
+			if err := os.Rename(target, target+BackupSuffix); err != nil {
+				return keepPrevious(), err
+			}
+		}
+		hash, err := extractFile(f, target)
+		if err != nil {
+			return keepPrevious(), err
+		}
+		installed[name] = hash
+	}
+	for name, hash := range previous {
+		if _, ok := installed[name]; ok {
+			continue
+		}
+		target := filepath.Join(userDir, filepath.FromSlash(name))
+		if current, err := crc32File(target); err != nil || current != hash {
+			// Already gone, or changed by the user
+			continue
+		}
+		if err := os.Remove(target); err != nil {
+			installed[name] = hash
+			return keepPrevious(), err
+		}
+		if exists(target + BackupSuffix) {
+			if err := os.Rename(target+BackupSuffix, target); err != nil {
+				return keepPrevious(), err
+			}
+		}
+		removeEmptyDirs(filepath.Dir(target), userDir)
+	}
+	return installed, nil
+}
+
+// Get the path of a zip entry relative to the Dolphin user directory, or false if it isn't a file
+// of the game in one of the DolphinExtraFolders or would escape the user directory. Settings and
+// textures of other games belong to the user and are never replaced: only GameSettings/<game ID>*.ini
+// and the files in Load/Textures/<game ID>/ are installed.
+func extraPath(name string, gameID string) (string, bool) {
+	name = path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, ":") {
+		return "", false
+	}
+	switch {
+	case path.Dir(name)+"/" == DolphinExtraFolders[0]:
+		base := path.Base(name)
+		if strings.HasPrefix(base, gameID) && strings.EqualFold(path.Ext(base), ".ini") {
+			return name, true
+		}
+	case strings.HasPrefix(name, DolphinExtraFolders[1]+gameID+"/"):
+		return name, true
+	}
+	return "", false
+}
+
+// Extract a zip entry to a file, returning its CRC32.
+func extractFile(f *zip.File, filePath string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	out, err := os.Create(filePath)
+	if err != nil {
+		return "", err
+	}
+	hash := crc32.NewIEEE()
+	_, err = io.Copy(io.MultiWriter(out, hash), rc)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return fmt.Sprintf("%08x", hash.Sum32()), err
+}
+
+// Get the CRC32 of a file without showing progress, for small files.
+func crc32File(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", hash.Sum32()), nil
+}
+
+// Remove a directory and its parents up to the root while they are empty.
+func removeEmptyDirs(dir string, root string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
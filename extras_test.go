@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Create a zip with the given files and get its entries.
+func writeTestZip(t *testing.T, files map[string]string) []*zip.File {
+	zipPath := filepath.Join(t.TempDir(), "patches.zip")
+	out, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(out)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	writer.Close()
+	out.Close()
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	return reader.File
+}
+
+func readTestFile(t *testing.T, filePath string) string {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Test that extras are installed, updated and removed while keeping the files of the user.
+func TestSyncDolphinExtras(t *testing.T) {
+	userDir := t.TempDir()
+	settings := filepath.Join(userDir, "GameSettings", "G4NJDA.ini")
+	texture := filepath.Join(userDir, "Load", "Textures", "G4NJDA", "tex1_64x64.png")
+	os.MkdirAll(filepath.Dir(settings), 0755)
+	os.WriteFile(settings, []byte("user settings"), 0644)
+
+	files := writeTestZip(t, map[string]string{
+		"vanilla.xdelta":                        "patch",
+		"GameSettings/G4NJDA.ini":               "[Gecko]\n$Code",
+		"Load/Textures/G4NJDA/tex1_64x64.png":   "texture",
+		"../GameSettings/escape.ini":            "escape",
+		"GameSettings/../../outside/escape.ini": "escape",
+	})
+	installed, err := syncDolphinExtras(userDir, "G4NJDA", files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 2 {
+		t.Errorf("Expected 2 installed files but got %v", installed)
+	}
+	if readTestFile(t, settings) != "[Gecko]\n$Code" || readTestFile(t, texture) != "texture" {
+		t.Error("Expected the extras to be installed")
+	}
+	if readTestFile(t, settings+BackupSuffix) != "user settings" {
+		t.Error("Expected the settings of the user to be backed up")
+	}
+	if exists(filepath.Join(filepath.Dir(userDir), "outside")) {
+		t.Error("Expected files outside the user directory to be left out")
+	}
+
+	files = writeTestZip(t, map[string]string{"GameSettings/G4NJDA.ini": "[Gecko]\n$New Code"})
+	installed, err = syncDolphinExtras(userDir, "G4NJDA", files, installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readTestFile(t, settings) != "[Gecko]\n$New Code" {
+		t.Error("Expected the settings to be updated")
+	}
+	if exists(filepath.Join(userDir, "Load")) {
+		t.Error("Expected the texture and its empty folders to be removed")
+	}
+	if readTestFile(t, settings+BackupSuffix) != "user settings" {
+		t.Error("Expected the backup to be kept")
+	}
+
+	installed, err = syncDolphinExtras(userDir, "G4NJDA", nil, installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 0 || readTestFile(t, settings) != "user settings" || exists(settings+BackupSuffix) {
+		t.Error("Expected the settings of the user to be restored")
+	}
+}
+
+// Test that installed files changed by the user are left alone when they are removed.
+func TestSyncDolphinExtrasKeepsChangedFiles(t *testing.T) {
+	userDir := t.TempDir()
+	files := writeTestZip(t, map[string]string{"GameSettings/G4NJDA.ini": "codes"})
+	installed, err := syncDolphinExtras(userDir, "G4NJDA", files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := filepath.Join(userDir, "GameSettings", "G4NJDA.ini")
+	os.WriteFile(settings, []byte("codes changed by the user"), 0644)
+	installed, err = syncDolphinExtras(userDir, "G4NJDA", nil, installed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 0 || readTestFile(t, settings) != "codes changed by the user" {
+		t.Error("Expected the changed file to be kept and no longer tracked")
+	}
+}
+
+// Test that only the settings and textures of the game are installed, not those of other games.
+func TestExtraPath(t *testing.T) {
+	tests := map[string]bool{
+		"GameSettings/G4NJDA.ini":             true,
+		"GameSettings/G4NJDAr1.ini":           true,
+		"Load/Textures/G4NJDA/tex1_64x64.png": true,
+		"Load/Textures/G4NJDA/sub/tex.png":    true,
+		"GameSettings/GALE01.ini":             false,
+		"GameSettings/G4NJDA.txt":             false,
+		"GameSettings/sub/G4NJDA.ini":         false,
+		"Load/Textures/GALE01/tex1_64x64.png": false,
+		"Load/Textures/G4NJDAX/tex.png":       false,
+		"Load/Textures/G4NJDA":                false,
+		"Load/GraphicMods/G4NJDA/mod.json":    false,
+		"../GameSettings/G4NJDA.ini":          false,
+	}
+	for name, allowed := range tests {
+		if _, ok := extraPath(name, "G4NJDA"); ok != allowed {
+			t.Errorf("Expected %s to be allowed: %t but got %t", name, allowed, ok)
+		}
+	}
+	userDir := t.TempDir()
+	other := filepath.Join(userDir, "GameSettings", "GALE01.ini")
+	os.MkdirAll(filepath.Dir(other), 0755)
+	os.WriteFile(other, []byte("settings of another game"), 0644)
+	files := writeTestZip(t, map[string]string{
+		"GameSettings/GALE01.ini":             "replaced",
+		"Load/Textures/GALE01/tex1_64x64.png": "texture",
+	})
+	installed, err := syncDolphinExtras(userDir, "G4NJDA", files, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 0 || readTestFile(t, other) != "settings of another game" || exists(filepath.Join(userDir, "Load")) {
+		t.Errorf("Expected the files of other games to be left out but got %v", installed)
+	}
+}
+
+// Test that the user directory is next to the config directory, or in the XDG data home.
+func TestFindDolphinUserDir(t *testing.T) {
+	home := t.TempDir()
+	getenv := func(string) string { return "" }
+	configDir := filepath.Join(home, "Documents", "Dolphin Emulator", "Config")
+	if dir := findDolphinUserDir(configDir, home, getenv); dir != filepath.Join(home, "Documents", "Dolphin Emulator") {
+		t.Errorf("Unexpected user directory %s", dir)
+	}
+	configDir = filepath.Join(home, ".config", "dolphin-emu")
+	if dir := findDolphinUserDir(configDir, home, getenv); dir != filepath.Join(home, ".local", "share", "dolphin-emu") {
+		t.Errorf("Unexpected user directory %s", dir)
+	}
+}
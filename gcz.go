@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+)
+
+// GCZMagic the magic number GCZ images start with
+const GCZMagic = 0xB10BC001
+
+// GCZHeaderSize the size of the header of a GCZ, before the block pointers and hashes
+const GCZHeaderSize = 0x20
+
+// GCZBlockSize the size of the blocks of written GCZs, the size Dolphin writes
+var GCZBlockSize int64 = 0x4000
+
+// gczUncompressed the flag of a block pointer of a block that is stored as is
+const gczUncompressed = 1 << 63
+
+// gczWriter writes a disc image as a GCZ, compressing each block with zlib unless that doesn't
+// make it smaller. The last block is padded with zeroes to the block size. The block pointers and
+// hashes are written last, since they are only known then.
+type gczWriter struct {
+	blockWriter
+	unreadableImage
+	output     io.WriteSeeker
+	pointers   []uint64
+	hashes     []uint32
+	offset     uint64
+	compressed bytes.Buffer
+}
+
+// Start writing a disc image of a size as a GCZ with blocks of a size.
+func newGCZWriter(output io.WriteSeeker, size int64, blockSize int64) (*gczWriter, error) {
+	count := (size + blockSize - 1) / blockSize
+	w := &gczWriter{output: output, pointers: make([]uint64, count), hashes: make([]uint32, count)}
+	w.blockWriter = blockWriter{size: size, block: make([]byte, blockSize), writeBlock: w.writeBlock}
+	if _, err := output.Seek(GCZHeaderSize+count*12, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *gczWriter) writeBlock(index int, block []byte) error {
+	if len(block) < len(w.block) {
+		block = append(block, make([]byte, len(w.block)-len(block))...)
+	}
+	w.compressed.Reset()
+	writer := zlib.NewWriter(&w.compressed)
+	writer.Write(block)
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	data := w.compressed.Bytes()
+	w.pointers[index] = w.offset
+	if len(data) >= len(block) {
+		data = block
+		w.pointers[index] |= gczUncompressed
+	}
+	w.hashes[index] = _adler32(data)
+	if _, err := w.output.Write(data); err != nil {
+		return err
+	}
+	w.offset += uint64(len(data))
+	return nil
+}
+
+func (w *gczWriter) finish() error {
+	if err := w.finishBlocks(); err != nil {
+		return err
+	}
+	count := len(w.pointers)
+	header := make([]byte, GCZHeaderSize+count*12)
+	binary.LittleEndian.PutUint32(header[0x00:], GCZMagic)
+	binary.LittleEndian.PutUint32(header[0x04:], 0) // GameCube disc
+	binary.LittleEndian.PutUint64(header[0x08:], w.offset)
+	binary.LittleEndian.PutUint64(header[0x10:], uint64(w.size))
+	binary.LittleEndian.PutUint32(header[0x18:], uint32(len(w.block)))
+	binary.LittleEndian.PutUint32(header[0x1C:], uint32(count))
+	for i := range w.pointers {
+		binary.LittleEndian.PutUint64(header[GCZHeaderSize+i*8:], w.pointers[i])
+		binary.LittleEndian.PutUint32(header[GCZHeaderSize+count*8+i*4:], w.hashes[i])
+	}
+	if _, err := w.output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.output.Write(header)
+	return err
+}
@@ -5,4 +5,6 @@ go 1.16
 require (
 	github.com/cheggaaa/pb/v3 v3.1.0
 	github.com/josephspurrier/goversioninfo v1.4.0 // indirect
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
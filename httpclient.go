@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// DefaultConnectTimeout default number of seconds to wait for a connection to be established
+const DefaultConnectTimeout = 15
+
+// UserAgent identifies the updater to the servers it downloads from
+var UserAgent = "Six-Patches-Of-Pain/" + Version + " (+https://github.com/NicholasMoser/Six-Patches-Of-Pain)"
+
+// sharedClient the client of every request, created from the config on first use
+var sharedClient *http.Client
+
+// Get the client shared by the release lookups and downloads.
+func httpClient() *http.Client {
+	if sharedClient == nil {
+		client, err := newHTTPClient()
+		if err != nil {
+			fail(ExitUsage, "Unable to configure the connection: %s", err.Error())
+		}
+		sharedClient = client
+	}
+	return sharedClient
+}
+
+// Create a client using the proxy, CA bundle and timeouts of the config. Without a proxy in the
+// config, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+func newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if config.CABundle != "" {
+		pool, err := loadCABundle(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	connectTimeout := time.Duration(DefaultConnectTimeout) * time.Second
+	if config.ConnectTimeout > 0 {
+		connectTimeout = time.Duration(config.ConnectTimeout) * time.Second
+	}
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	// Downloads enforce the read timeout on the body themselves, see timeoutReader
+	_, readTimeout := downloadSettings()
+	transport.ResponseHeaderTimeout = readTimeout
+	return &http.Client{Transport: &userAgentTransport{transport: transport}}, nil
+}
+
+// Load the certificates of a PEM file, in addition to the ones of the system.
+func loadCABundle(filePath string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + filePath)
+	}
+	return pool, nil
+}
+
+// userAgentTransport sets the User-Agent of every request
+type userAgentTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	return t.transport.RoundTrip(req)
+}
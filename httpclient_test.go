@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that requests identify the updater and go through the configured proxy.
+func TestHTTPClientProxy(t *testing.T) {
+	proxied := ""
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		if r.Header.Get("User-Agent") != UserAgent {
+			t.Errorf("Expected User-Agent %s but got %s", UserAgent, r.Header.Get("User-Agent"))
+		}
+	}))
+	defer proxy.Close()
+	defer func() { config = Config{} }()
+	config = Config{Proxy: proxy.URL}
+
+	client, err := newHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("http://releases.example.com/releases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxied != "http://releases.example.com/releases.json" {
+		t.Errorf("Expected the request to go through the proxy but got %q", proxied)
+	}
+}
+
+// Test that servers with a certificate of a custom CA are only trusted with the CA bundle.
+func TestHTTPClientCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	defer func() { config = Config{} }()
+
+	config = Config{}
+	client, err := newHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("Expected the certificate of the server to be untrusted")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	os.WriteFile(bundle, certificate, 0644)
+	config = Config{CABundle: bundle}
+	client, err = newHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	config = Config{CABundle: filepath.Join(t.TempDir(), "missing.pem")}
+	if _, err := newHTTPClient(); err == nil {
+		t.Error("Expected a missing CA bundle to be an error")
+	}
+}
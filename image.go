@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OutputFormats the formats a patched ISO can be saved in: a raw ISO, or a CISO, GCZ or RVZ,
+// which take less space and can be played by Dolphin as well
+var OutputFormats = []string{"iso", "ciso", "gcz", "rvz"}
+
+// argOutputFormat the format given as argument to save the patched ISO in
+var argOutputFormat string
+
+// Get the format to save the patched ISO in, preferring the argument over the config.
+func outputFormat() string {
+	format := argOutputFormat
+	if format == "" {
+		format = config.OutputFormat
+	}
+	if format == "" {
+		return OutputFormats[0]
+	}
+	for _, known := range OutputFormats {
+		if format == known {
+			return format
+		}
+	}
+	fail(ExitUsage, "Unknown output format %s, expected one of %s", format, strings.Join(OutputFormats, ", "))
+	return ""
+}
+
+// Get the path of an image in a format, replacing the extension of the ISO path.
+func imagePath(isoPath string, format string) string {
+	return strings.TrimSuffix(isoPath, filepath.Ext(isoPath)) + "." + format
+}
+
+// imageWriter writes a disc image in a format as the patched data comes in, in order. Only a raw
+// ISO can read back what was written, for patches that copy from earlier windows of the target.
+type imageWriter interface {
+	io.Writer
+	io.ReaderAt
+	// finish writes what can only be written once the whole image is known
+	finish() error
+}
+
+// Start writing a disc image of a size in a format to a file.
+func newImageWriter(output *os.File, format string, size int64) (imageWriter, error) {
+	switch format {
+	case "ciso":
+		return newCISOWriter(output, size, CISOBlockSize)
+	case "gcz":
+		return newGCZWriter(output, size, GCZBlockSize)
+	case "rvz":
+		return newRVZWriter(output, size, RVZChunkSize)
+	}
+	return &isoWriter{File: output, size: size}, nil
+}
+
+// Get the most space a disc image of a size can take in a format.
+func maxImageSize(format string, size int64) int64 {
+	switch format {
+	case "ciso":
+		blocks := (size + CISOBlockSize - 1) / CISOBlockSize
+		return CISOHeaderSize + blocks*CISOBlockSize
+	case "gcz":
+		blocks := (size + GCZBlockSize - 1) / GCZBlockSize
+		return GCZHeaderSize + blocks*(12+GCZBlockSize)
+	case "rvz":
+		chunks := (size + RVZChunkSize - 1) / RVZChunkSize
+		return rvzHeadersSize + size + chunks*(4+rvzGroupEntrySize) + 0x1000
+	}
+	return size
+}
+
+// isoWriter writes a disc image as a raw ISO, as is.
+type isoWriter struct {
+	*os.File
+	size    int64
+	written int64
+}
+
+func (w *isoWriter) Write(p []byte) (int, error) {
+	n, err := w.File.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *isoWriter) finish() error {
+	return checkImageSize(w.written, w.size)
+}
+
+// Fail when a writer didn't get the whole image, e.g. when the patch holds less than its headers say.
+func checkImageSize(written int64, size int64) error {
+	if written != size {
+		return fmt.Errorf("expected a %d byte image but got %d bytes", size, written)
+	}
+	return nil
+}
+
+// errNotReadable a compressed image can't be read back while it is being written
+var errNotReadable = errors.New("the patch reads back the patched image, which only works with -output-format iso")
+
+// unreadableImage the ReadAt of the writers of compressed images
+type unreadableImage struct{}
+
+func (unreadableImage) ReadAt(p []byte, off int64) (int, error) {
+	return 0, errNotReadable
+}
+
+// blockWriter splits the data written to it into blocks, for the formats that store a disc image
+// block by block. The last block is passed on as is, so it may be shorter.
+type blockWriter struct {
+	size       int64
+	block      []byte
+	n          int
+	index      int
+	written    int64
+	writeBlock func(index int, block []byte) error
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	if w.written+int64(len(p)) > w.size {
+		return 0, fmt.Errorf("expected a %d byte image but got more", w.size)
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.block[w.n:], p)
+		w.n += n
+		p = p[n:]
+		written += n
+		w.written += int64(n)
+		if w.n == len(w.block) {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Pass on the block written so far, if any.
+func (w *blockWriter) flush() error {
+	if w.n == 0 {
+		return nil
+	}
+	err := w.writeBlock(w.index, w.block[:w.n])
+	w.index++
+	w.n = 0
+	return err
+}
+
+// Pass on the last block and check that the whole image was written.
+func (w *blockWriter) finishBlocks() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return checkImageSize(w.written, w.size)
+}
+
+// Check whether a block holds only zeroes, which the compressed formats leave out.
+func isZeroes(block []byte) bool {
+	for _, b := range block {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
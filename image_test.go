@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Create a disc image with a header, empty space and some data, and a file to write an image to.
+func testImage(t *testing.T) ([]byte, *os.File) {
+	image := make([]byte, 0x29010)
+	copy(image, "G4NJDA")
+	for i := 0x20000; i < 0x24000; i++ {
+		image[i] = byte(i)
+	}
+	image[len(image)-1] = 0xFF
+	output, err := os.Create(filepath.Join(t.TempDir(), "image"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { output.Close() })
+	return image, output
+}
+
+func readAll(t *testing.T, f *os.File) []byte {
+	f.Seek(0, io.SeekStart)
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Write an image in pieces that don't line up with the blocks, as the patched windows don't.
+func writeTestImage(t *testing.T, image []byte, writer imageWriter) {
+	for start := 0; start < len(image); start += 0x3001 {
+		end := start + 0x3001
+		if end > len(image) {
+			end = len(image)
+		}
+		if _, err := writer.Write(image[start:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.finish(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Decode a GCZ back into the image it holds, checking the hash of each block.
+func decodeGCZ(t *testing.T, data []byte) []byte {
+	if binary.LittleEndian.Uint32(data) != GCZMagic {
+		t.Fatal("Unexpected GCZ header")
+	}
+	count := int(binary.LittleEndian.Uint32(data[0x1C:]))
+	dataStart := GCZHeaderSize + count*12
+	if int(binary.LittleEndian.Uint64(data[0x08:])) != len(data)-dataStart {
+		t.Error("Unexpected compressed data size")
+	}
+	var decoded []byte
+	for i := 0; i < count; i++ {
+		pointer := binary.LittleEndian.Uint64(data[GCZHeaderSize+i*8:])
+		end := uint64(len(data) - dataStart)
+		if i+1 < count {
+			end = binary.LittleEndian.Uint64(data[GCZHeaderSize+(i+1)*8:]) &^ gczUncompressed
+		}
+		block := data[dataStart+int(pointer&^gczUncompressed) : dataStart+int(end)]
+		if _adler32(block) != binary.LittleEndian.Uint32(data[GCZHeaderSize+count*8+i*4:]) {
+			t.Errorf("Unexpected hash of block %d", i)
+		}
+		if pointer&gczUncompressed == 0 {
+			reader, err := zlib.NewReader(bytes.NewReader(block))
+			if err != nil {
+				t.Fatal(err)
+			}
+			block, err = io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		decoded = append(decoded, block...)
+	}
+	return decoded[:binary.LittleEndian.Uint64(data[0x10:])]
+}
+
+// Test that a GCZ can be read back into the original image.
+func TestWriteGCZ(t *testing.T) {
+	image, output := testImage(t)
+	writer, err := newGCZWriter(output, int64(len(image)), 0x4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestImage(t, image, writer)
+	data := readAll(t, output)
+	if !bytes.Equal(decodeGCZ(t, data), image) {
+		t.Error("Expected the GCZ to hold the image")
+	}
+	if len(data) >= len(image) {
+		t.Errorf("Expected the GCZ to be smaller than %d bytes but it is %d", len(image), len(data))
+	}
+}
+
+// Decompress an LZMA2 stream of an RVZ.
+func decodeLZMA2(t *testing.T, data []byte, dictSize int) []byte {
+	reader, err := lzma.Reader2Config{DictCap: dictSize}.NewReader2(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// Test that an RVZ can be read back into the original image, the way Dolphin reads it.
+func TestWriteRVZ(t *testing.T) {
+	image, output := testImage(t)
+	chunkSize := 0x8000
+	writer, err := newRVZWriter(output, int64(len(image)), int64(chunkSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestImage(t, image, writer)
+	data := readAll(t, output)
+	if len(data) >= len(image) {
+		t.Errorf("Expected the RVZ to be smaller than %d bytes but it is %d", len(image), len(data))
+	}
+
+	header1, header2 := data[:0x48], data[0x48:rvzHeadersSize]
+	if string(header1[:4]) != "RVZ\x01" || binary.BigEndian.Uint64(header1[0x24:]) != uint64(len(image)) ||
+		binary.BigEndian.Uint64(header1[0x2C:]) != uint64(len(data)) {
+		t.Fatal("Unexpected RVZ header")
+	}
+	if hash := sha1.Sum(header2); !bytes.Equal(header1[0x10:0x24], hash[:]) {
+		t.Error("Unexpected hash of the second header")
+	}
+	if hash := sha1.Sum(header1[:0x34]); !bytes.Equal(header1[0x34:], hash[:]) {
+		t.Error("Unexpected hash of the first header")
+	}
+	if binary.BigEndian.Uint32(header2) != 1 || binary.BigEndian.Uint32(header2[0x04:]) != rvzLZMA2 ||
+		binary.BigEndian.Uint32(header2[0x0C:]) != uint32(chunkSize) || !bytes.Equal(header2[0x10:0x90], image[:0x80]) {
+		t.Fatal("Unexpected second RVZ header")
+	}
+	// A dictionary of 32 KiB
+	if header2[0xD4] != 1 || header2[0xD5] != 6 {
+		t.Errorf("Unexpected LZMA2 properties %v", header2[0xD4:0xD6])
+	}
+
+	offset := binary.BigEndian.Uint64(header2[0xB8:])
+	rawData := decodeLZMA2(t, data[offset:offset+uint64(binary.BigEndian.Uint32(header2[0xC0:]))], chunkSize)
+	count := binary.BigEndian.Uint32(header2[0xC4:])
+	if len(rawData) != 0x18 || binary.BigEndian.Uint64(rawData) != 0x80 ||
+		binary.BigEndian.Uint64(rawData[0x08:]) != uint64(len(image)-0x80) || binary.BigEndian.Uint32(rawData[0x14:]) != count {
+		t.Fatalf("Unexpected raw data entry %X", rawData)
+	}
+	offset = binary.BigEndian.Uint64(header2[0xC8:])
+	groups := decodeLZMA2(t, data[offset:offset+uint64(binary.BigEndian.Uint32(header2[0xD0:]))], chunkSize)
+	if len(groups) != int(count)*rvzGroupEntrySize {
+		t.Fatalf("Expected %d group entries but got %d bytes", count, len(groups))
+	}
+	var decoded []byte
+	for i := 0; i < int(count); i++ {
+		start := uint64(binary.BigEndian.Uint32(groups[i*rvzGroupEntrySize:])) * 4
+		size := binary.BigEndian.Uint32(groups[i*rvzGroupEntrySize+4:])
+		group := data[start : start+uint64(size&^rvzCompressed)]
+		if size == 0 {
+			group = make([]byte, chunkSize)
+		} else if size&rvzCompressed != 0 {
+			group = decodeLZMA2(t, group, chunkSize)
+		}
+		decoded = append(decoded, group...)
+	}
+	if !bytes.Equal(decoded[:len(image)], image) {
+		t.Error("Expected the RVZ to hold the image")
+	}
+}
+
+// Test that a writer fails when it doesn't get exactly the size of the image.
+func TestImageWriterSize(t *testing.T) {
+	for _, format := range OutputFormats {
+		image, output := testImage(t)
+		writer, err := newImageWriter(output, format, int64(len(image))+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer.Write(image)
+		if err := writer.finish(); err == nil {
+			t.Errorf("Expected %s to fail for a missing byte", format)
+		}
+		if format == "iso" {
+			continue
+		}
+		if _, err := writer.Write([]byte{0, 0}); err == nil {
+			t.Errorf("Expected %s to fail for an extra byte", format)
+		}
+		if _, err := writer.ReadAt(make([]byte, 1), 0); err == nil {
+			t.Errorf("Expected %s to fail to read back the image", format)
+		}
+	}
+}
+
+// Test that the extension of the ISO is replaced by the format.
+func TestImagePath(t *testing.T) {
+	if path := imagePath(filepath.Join("ISOs", "SCON4-v1.2.0.iso"), "gcz"); path != filepath.Join("ISOs", "SCON4-v1.2.0.gcz") {
+		t.Errorf("Unexpected image path %s", path)
+	}
+}
+
+// Test that a patch is written straight into a compressed image, without an ISO next to it, and
+// passed on to the game for Nintendont.
+func TestPatchBaseISO(t *testing.T) {
+	applyProfile(builtinProfiles[0])
+	dir := t.TempDir()
+	drive := filepath.Join(dir, "sd")
+	check(os.Mkdir(drive, 0755))
+	outputImage := filepath.Join(dir, "patched.gcz")
+	patchPath := "test/ImageDelta/patch.xdelta"
+	expected, err := os.ReadFile("test/ImageDelta/output.jpg")
+	check(err)
+
+	game := &nintendontGame{dir: drive, format: "ciso", size: int64(len(expected))}
+	patchBaseISO(Iso{filePath: "test/ImageDelta/input.jpg", isFile: true}, patchPath, outputImage, "gcz", game)
+	data, err := os.ReadFile(outputImage)
+	check(err)
+	if !bytes.Equal(decodeGCZ(t, data), expected) {
+		t.Error("Expected the GCZ to hold the patched file")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "patched*")); len(files) != 1 {
+		t.Errorf("Expected only the GCZ to be written but got %v", files)
+	}
+
+	target, err := game.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != nintendontPath(drive, profile.ModName, string(expected[:6]), "ciso") {
+		t.Errorf("Unexpected game path %s", target)
+	}
+	ciso, err := os.ReadFile(target)
+	check(err)
+	if !bytes.Equal(ciso[CISOHeaderSize:CISOHeaderSize+len(expected)], expected) {
+		t.Error("Expected the CISO to hold the patched file")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// LaunchModes whether to start the patched ISO in Dolphin after an update, and whether Dolphin
+// exits when the game is stopped (batch)
+var LaunchModes = []string{"off", "on", "batch"}
+
+// argLaunch boolean that specifies to start the patched ISO in Dolphin after updating
+var argLaunch bool
+
+// Get how to launch Dolphin, the -launch argument turning it on if the config doesn't.
+func launchMode() string {
+	if argLaunch && (config.Launch == "" || config.Launch == "off") {
+		return "on"
+	}
+	return config.Launch
+}
+
+// Find the Dolphin executable: the configured path, the Dolphin directory, the PATH and then the
+// usual install locations of the OS.
+func findDolphinExecutable(dolphinPath string, dolphinDir string, home string, goos string,
+	getenv func(string) string, lookPath func(string) (string, error)) (string, bool) {
+	var candidates []string
+	if dolphinPath != "" {
+		candidates = append(candidates, appExecutable(dolphinPath))
+	}
+	if dolphinDir != "" {
+		switch goos {
+		case "windows":
+			candidates = append(candidates, filepath.Join(dolphinDir, "Dolphin.exe"))
+		case "darwin":
+			candidates = append(candidates, appExecutable(filepath.Join(dolphinDir, "Dolphin.app")))
+		default:
+			candidates = append(candidates, filepath.Join(dolphinDir, "dolphin-emu"))
+		}
+	}
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return candidate, true
+		}
+	}
+	for _, name := range []string{"dolphin-emu", "Dolphin"} {
+		if path, err := lookPath(name); err == nil {
+			return path, true
+		}
+	}
+	switch goos {
+	case "windows":
+		for _, dir := range []string{getenv("ProgramFiles"), getenv("ProgramFiles(x86)")} {
+			if dir != "" {
+				candidates = append(candidates, filepath.Join(dir, "Dolphin", "Dolphin.exe"),
+					filepath.Join(dir, "Dolphin-x64", "Dolphin.exe"))
+			}
+		}
+	case "darwin":
+		candidates = append(candidates, appExecutable("/Applications/Dolphin.app"),
+			appExecutable(filepath.Join(home, "Applications", "Dolphin.app")))
+	default:
+		candidates = append(candidates, "/usr/games/dolphin-emu",
+			"/var/lib/flatpak/exports/bin/org.DolphinEmu.dolphin-emu",
+			filepath.Join(home, ".local", "share", "flatpak", "exports", "bin", "org.DolphinEmu.dolphin-emu"))
+	}
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Get the executable inside a Mac app bundle, or the path itself if it isn't one.
+func appExecutable(path string) string {
+	if strings.HasSuffix(strings.TrimSuffix(path, "/"), ".app") {
+		return filepath.Join(path, "Contents", "MacOS", "Dolphin")
+	}
+	return path
+}
+
+// Get the arguments to start an ISO in Dolphin with. A Dolphin directory without a portable.txt
+// is a user directory, which Dolphin has to be told about.
+func dolphinArgs(isoPath string, batch bool, dolphinDir string) []string {
+	args := []string{"-e", isoPath}
+	if batch {
+		args = append(args, "-b")
+	}
+	if dolphinDir != "" && !exists(filepath.Join(dolphinDir, "portable.txt")) {
+		args = append(args, "-u", dolphinDir)
+	}
+	return args
+}
+
+// Start the patched ISO in Dolphin without waiting for it. The exit code tells whether the update
+// worked, so a Dolphin that can't be found or started is printed rather than turned into a failure.
+func launchDolphin(isoPath string) {
+	mode := launchMode()
+	if mode == "" || mode == "off" {
+		return
+	}
+	home, _ := os.UserHomeDir()
+	executable, found := findDolphinExecutable(config.DolphinPath, config.DolphinDir, home, runtime.GOOS, os.Getenv, exec.LookPath)
+	if !found {
+		fmt.Println("Unable to find Dolphin, set its executable with: config set dolphin_path <executable>")
+		return
+	}
+	absPath, err := filepath.Abs(isoPath)
+	check(err)
+	cmd := exec.Command(executable, dolphinArgs(absPath, mode == "batch", config.DolphinDir)...)
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("Unable to start %s: %s\n", executable, err.Error())
+		return
+	}
+	cmd.Process.Release()
+	fmt.Printf("Started %s in Dolphin\n", isoPath)
+	emit(Event{Event: "launched", Path: absPath})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Test that Dolphin is found at the configured path, on the PATH and at the usual locations.
+func TestFindDolphinExecutable(t *testing.T) {
+	home := t.TempDir()
+	getenv := func(key string) string {
+		if key == "ProgramFiles" {
+			return filepath.Join(home, "Program Files")
+		}
+		return ""
+	}
+	notFound := func(string) (string, error) { return "", errors.New("not found") }
+	create := func(path string) string {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, nil, 0755)
+		return path
+	}
+
+	if _, found := findDolphinExecutable("", "", home, "windows", getenv, notFound); found {
+		t.Fatal("Expected no Dolphin to be found")
+	}
+	installed := create(filepath.Join(home, "Program Files", "Dolphin", "Dolphin.exe"))
+	if path, _ := findDolphinExecutable("", "", home, "windows", getenv, notFound); path != installed {
+		t.Errorf("Expected the installed Dolphin but got %s", path)
+	}
+	onPath := func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	if path, _ := findDolphinExecutable("", "", home, "windows", getenv, onPath); path != "/usr/bin/dolphin-emu" {
+		t.Errorf("Expected Dolphin on the PATH but got %s", path)
+	}
+	portable := create(filepath.Join(home, "Dolphin-x64", "Dolphin.exe"))
+	if path, _ := findDolphinExecutable("", filepath.Dir(portable), home, "windows", getenv, onPath); path != portable {
+		t.Errorf("Expected the Dolphin of the Dolphin directory but got %s", path)
+	}
+	app := create(filepath.Join(home, "Applications", "Dolphin.app", "Contents", "MacOS", "Dolphin"))
+	if path, _ := findDolphinExecutable(filepath.Join(home, "Applications", "Dolphin.app"), "", home, "darwin", getenv, onPath); path != app {
+		t.Errorf("Expected the executable of the configured app but got %s", path)
+	}
+	if path, _ := findDolphinExecutable("", "", home, "darwin", getenv, notFound); path != app {
+		t.Errorf("Expected the app in Applications but got %s", path)
+	}
+}
+
+// Test that a user directory is passed to Dolphin, but a portable install isn't.
+func TestDolphinArgs(t *testing.T) {
+	userDir := t.TempDir()
+	args := dolphinArgs("/isos/SCON4.iso", true, userDir)
+	if expected := []string{"-e", "/isos/SCON4.iso", "-b", "-u", userDir}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v but got %v", expected, args)
+	}
+	os.WriteFile(filepath.Join(userDir, "portable.txt"), nil, 0644)
+	args = dolphinArgs("/isos/SCON4.iso", false, userDir)
+	if expected := []string{"-e", "/isos/SCON4.iso"}; !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v but got %v", expected, args)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockFile the name of the lock file in the data directory, held while a run changes it
+var LockFile = "lock.json"
+
+// TempDir the name of the folder in the data directory with a folder of temporary files per run
+var TempDir = "tmp"
+
+// LockMaxAge the age after which a lock of another computer is stale, since whether its process
+// is still running can't be checked
+var LockMaxAge = 12 * time.Hour
+
+// runID identifies this run, so that its temporary files don't clash with those of another run
+var runID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
+// heldLock the path of the lock file this run holds, if any
+var heldLock string
+
+// lockOwner the process holding a lock, as written to the lock file
+type lockOwner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Version string    `json:"version"`
+	// Run the run that holds the lock, which tells apart runs of the same process
+	Run string `json:"run"`
+}
+
+// errLocked the data directory is in use by another run
+type errLocked struct {
+	owner lockOwner
+}
+
+func (e *errLocked) Error() string {
+	return fmt.Sprintf("in use by process %d on %s since %s", e.owner.PID, e.owner.Host, e.owner.Started.Local().Format("2006-01-02 15:04:05"))
+}
+
+// Get the folder of the temporary files of this run, such as the downloaded patch.
+func runDir() string {
+	return filepath.Join(DATA, TempDir, runID)
+}
+
+// Lock the data directory for this run, failing with ExitLocked if another run is using it.
+// Scheduled updates and updates started by hand would otherwise clobber each other's files.
+// The folders of earlier runs that didn't finish are removed once the lock is held, while their
+// partial downloads are kept to be resumed.
+func lockDataDir() {
+	if heldLock != "" {
+		return
+	}
+	err := os.MkdirAll(DATA, 0755)
+	check(err)
+	path := filepath.Join(DATA, LockFile)
+	host, _ := os.Hostname()
+	err = acquireLock(path, lockOwner{PID: os.Getpid(), Host: host, Started: time.Now(), Version: Version, Run: runID})
+	var locked *errLocked
+	if errors.As(err, &locked) {
+		fail(ExitLocked, "%s is %s. Wait for it to finish, or delete %s if it isn't running anymore",
+			DATA, locked.Error(), path)
+	} else if err != nil {
+		fail(ExitFailure, "Unable to lock %s: %s", DATA, err.Error())
+	}
+	heldLock = path
+	removeStaleRunDirs()
+	err = os.MkdirAll(runDir(), 0755)
+	check(err)
+}
+
+// Unlock the data directory and remove the temporary files of this run.
+func unlockDataDir() {
+	if heldLock == "" {
+		return
+	}
+	os.RemoveAll(runDir())
+	os.Remove(filepath.Join(DATA, TempDir))
+	os.Remove(heldLock)
+	heldLock = ""
+}
+
+// Remove the folders of temporary files of runs whose process isn't running anymore. They are
+// named after the process ID, see runID.
+func removeStaleRunDirs() {
+	entries, _ := os.ReadDir(filepath.Join(DATA, TempDir))
+	for _, entry := range entries {
+		var pid int
+		var started int64
+		if !entry.IsDir() || entry.Name() == runID {
+			continue
+		}
+		if _, err := fmt.Sscanf(entry.Name(), "%d-%d", &pid, &started); err != nil {
+			continue
+		}
+		// A folder of this process is left by an earlier process with the same ID
+		if pid != os.Getpid() && processRunning(pid) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(DATA, TempDir, entry.Name()))
+	}
+}
+
+// Create a lock file held by an owner, replacing it if it is stale. Returns an errLocked if it is
+// held by a process that is still running.
+func acquireLock(path string, owner lockOwner) error {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	// Give up after a few attempts in case another run keeps replacing the same stale lock
+	for attempt := 0; attempt < 3; attempt++ {
+		err := createLock(path, data)
+		if err == nil || !os.IsExist(err) {
+			return err
+		}
+		existing, err := readLock(path)
+		if os.IsNotExist(err) {
+			// Released in the meantime
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !lockStale(existing, owner.Host, time.Now()) {
+			return &errLocked{owner: existing}
+		}
+		// Move it aside before removing it. When several runs find the same stale lock, another
+		// run may already have replaced it with its own lock by now, so what was moved is only
+		// removed if it is still the stale lock and is put back otherwise. Putting it back
+		// never replaces a lock that yet another run created in the meantime.
+		stale := path + "." + owner.Run
+		if err := os.Rename(path, stale); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		moved, err := readLock(stale)
+		if err != nil {
+			putBackLock(stale, path)
+			return err
+		}
+		if !sameOwner(moved, existing) {
+			if err := putBackLock(stale, path); err != nil {
+				return err
+			}
+			return &errLocked{owner: moved}
+		}
+		fmt.Printf("Removing the stale lock of process %d from %s\n", existing.PID, existing.Started.Local().Format("2006-01-02 15:04:05"))
+		os.Remove(stale)
+	}
+	return errors.New("the lock keeps changing")
+}
+
+// Create a lock file with the given contents, failing with an error for which os.IsExist is true
+// if it already exists.
+func createLock(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Put back a lock that was moved aside from path, unless another lock was created there since,
+// which must not be replaced.
+func putBackLock(moved string, path string) error {
+	data, err := ioutil.ReadFile(moved)
+	if err == nil {
+		err = createLock(path, data)
+	}
+	os.Remove(moved)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Read the owner of a lock file. A lock that can't be parsed is being written by another run,
+// unless it is older than a minute.
+func readLock(path string) (lockOwner, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return lockOwner{}, err
+	}
+	owner := lockOwner{Started: info.ModTime()}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return lockOwner{}, err
+	}
+	if json.Unmarshal(data, &owner) != nil && time.Since(info.ModTime()) < time.Minute {
+		owner.PID = -1
+	}
+	return owner, nil
+}
+
+// Check whether a lock was left behind by a process that isn't running anymore. Processes of
+// other computers sharing the data directory can't be checked, so their locks are only stale
+// once they are older than LockMaxAge.
+func lockStale(owner lockOwner, host string, now time.Time) bool {
+	switch {
+	case owner.PID < 0:
+		return false
+	case owner.PID == 0:
+		return true
+	case owner.Host != host:
+		return now.Sub(owner.Started) > LockMaxAge
+	}
+	return !processRunning(owner.PID)
+}
+
+// Check whether two lock files were written by the same run.
+func sameOwner(a lockOwner, b lockOwner) bool {
+	return a.PID == b.PID && a.Host == b.Host && a.Run == b.Run && a.Started.Equal(b.Started)
+}
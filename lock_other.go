@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// Check whether a process is running by sending it the null signal, which only checks whether
+// it could be signalled.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
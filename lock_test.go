@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Write a lock file held by a process.
+func writeLock(t *testing.T, path string, owner lockOwner) {
+	data, err := json.Marshal(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Get the owner of a lock for a run of this process.
+func testOwner(run string) lockOwner {
+	host, _ := os.Hostname()
+	return lockOwner{PID: os.Getpid(), Host: host, Started: time.Now(), Run: run}
+}
+
+// Test that a lock held by a running process is respected and one of a process that exited is
+// replaced.
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockFile)
+	if err := acquireLock(path, testOwner("a")); err != nil {
+		t.Fatal(err)
+	}
+	owner, err := readLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.PID != os.Getpid() {
+		t.Errorf("Expected the lock of process %d but got %d", os.Getpid(), owner.PID)
+	}
+
+	host, _ := os.Hostname()
+	writeLock(t, path, lockOwner{PID: os.Getppid(), Host: host, Started: time.Now()})
+	var locked *errLocked
+	if err := acquireLock(path, testOwner("b")); !errors.As(err, &locked) {
+		t.Fatalf("Expected the lock of the parent process to be held but got %v", err)
+	}
+	if locked.owner.PID != os.Getppid() {
+		t.Errorf("Expected the lock to be held by %d but got %d", os.Getppid(), locked.owner.PID)
+	}
+
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	writeLock(t, path, lockOwner{PID: exited.Process.Pid, Host: host, Started: time.Now()})
+	if err := acquireLock(path, testOwner("c")); err != nil {
+		t.Fatalf("Expected the lock of an exited process to be stale but got %v", err)
+	}
+}
+
+// Test when locks of other computers and locks that are still being written are stale.
+func TestLockStale(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		owner lockOwner
+		stale bool
+	}{
+		{lockOwner{PID: 1234, Host: "other", Started: now.Add(-time.Hour)}, false},
+		{lockOwner{PID: 1234, Host: "other", Started: now.Add(-LockMaxAge - time.Hour)}, true},
+		{lockOwner{PID: -1, Host: "this", Started: now}, false},
+		{lockOwner{PID: 0, Started: now.Add(-time.Hour)}, true},
+		{lockOwner{PID: os.Getpid(), Host: "this", Started: now}, false},
+	}
+	for _, test := range tests {
+		if stale := lockStale(test.owner, "this", now); stale != test.stale {
+			t.Errorf("Expected %+v to be stale: %t but got %t", test.owner, test.stale, stale)
+		}
+	}
+}
+
+// Test that when two runs race to replace the same stale lock, only one of them gets it.
+func TestAcquireStaleLockRace(t *testing.T) {
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for i := 0; i < 50; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("lock-%d.json", i))
+		host, _ := os.Hostname()
+		writeLock(t, path, lockOwner{PID: exited.Process.Pid, Host: host, Started: time.Now().Add(-time.Hour)})
+		errs := make([]error, 2)
+		var wg sync.WaitGroup
+		for j := range errs {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				errs[j] = acquireLock(path, testOwner(fmt.Sprintf("run-%d", j)))
+			}(j)
+		}
+		wg.Wait()
+		held := 0
+		for _, err := range errs {
+			var locked *errLocked
+			if err == nil {
+				held++
+			} else if !errors.As(err, &locked) {
+				t.Fatalf("Expected the lock to be held by the other run but got %v", err)
+			}
+		}
+		if held != 1 {
+			t.Fatalf("Expected exactly one run to get the lock but %d did", held)
+		}
+		if owner, err := readLock(path); err != nil || owner.PID != os.Getpid() {
+			t.Fatalf("Expected the lock to be held by the run that got it but got %+v, %v", owner, err)
+		}
+		if matches, _ := filepath.Glob(path + ".*"); len(matches) > 0 {
+			t.Fatalf("Expected no stale locks left behind but got %v", matches)
+		}
+	}
+}
+
+// Test that putting back a lock that was moved aside by mistake doesn't replace a lock created
+// in the meantime.
+func TestPutBackLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, LockFile)
+	moved := path + ".b"
+	writeLock(t, moved, testOwner("a"))
+	if err := putBackLock(moved, path); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := readLock(path); err != nil || owner.Run != "a" {
+		t.Fatalf("Expected the lock of run a to be put back but got %+v, %v", owner, err)
+	}
+
+	writeLock(t, moved, testOwner("b"))
+	if err := putBackLock(moved, path); err != nil {
+		t.Fatal(err)
+	}
+	if owner, err := readLock(path); err != nil || owner.Run != "a" {
+		t.Errorf("Expected the newer lock of run a to be kept but got %+v, %v", owner, err)
+	}
+	if exists(moved) {
+		t.Error("Expected the moved lock to be removed")
+	}
+}
+
+// Test that locking the data directory gives the run its own folder of temporary files and
+// removes those of earlier runs that exited, but keeps partial downloads to resume them.
+func TestLockDataDir(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	exited := exec.Command(os.Args[0], "-test.run=^$")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	leftover := filepath.Join(DATA, TempDir, fmt.Sprintf("%d-1", exited.Process.Pid), "saves.json")
+	running := filepath.Join(DATA, TempDir, fmt.Sprintf("%d-1", os.Getppid()), "saves.json")
+	part := PatchFile + ".part"
+	for _, file := range []string{leftover, running, part} {
+		os.MkdirAll(filepath.Dir(file), 0755)
+		ioutil.WriteFile(file, []byte("old"), 0644)
+	}
+
+	lockDataDir()
+	if exists(leftover) {
+		t.Error("Expected the temporary files of an earlier run to be removed")
+	}
+	if !exists(running) {
+		t.Error("Expected the temporary files of a running process to be kept")
+	}
+	if !exists(part) {
+		t.Error("Expected the partial download to be kept")
+	}
+	if filepath.Dir(PatchFile) != filepath.Join(DATA, TempDir) || !exists(runDir()) {
+		t.Errorf("Expected %s to be in the temporary files folder and %s to exist", PatchFile, runDir())
+	}
+	unlockDataDir()
+	if exists(filepath.Join(DATA, LockFile)) || exists(runDir()) {
+		t.Error("Expected the lock and the temporary files of the run to be removed on unlock")
+	}
+	if !exists(part) {
+		t.Error("Expected the partial download to be kept for the next run")
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "syscall"
+
+// processQueryLimitedInformation the PROCESS_QUERY_LIMITED_INFORMATION access right
+const processQueryLimitedInformation = 0x1000
+
+// stillActive the exit code of a process that hasn't exited yet
+const stillActive = 259
+
+// Check whether a process is running by opening it and reading its exit code.
+func processRunning(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// A process of another user can't be opened but is still running
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(handle)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
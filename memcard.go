@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Layout of a GameCube memory card: a header block, two copies of the directory and two copies
+// of the block allocation table (BAT), followed by the blocks of the saves
+const (
+	cardBlockSize     = 0x2000
+	cardDirBlock      = 1
+	cardBATBlock      = 3
+	cardFirstBlock    = 5
+	cardEntrySize     = 0x40
+	cardEntries       = 127
+	cardDirCounter    = 0x1FFA
+	cardDirChecksum   = 0x1FFC
+	cardBATCounter    = 0x04
+	cardBATFreeBlocks = 0x06
+	cardBATLastAlloc  = 0x08
+	cardBATMap        = 0x0A
+	cardEntryFirst    = 0x36
+	cardEntryBlocks   = 0x38
+	cardLastBlock     = 0xFFFF
+)
+
+// GCIHeaderSize the size of the directory entry a GCI file starts with, before the save data
+const GCIHeaderSize = cardEntrySize
+
+// memoryCard a raw GameCube memory card image, such as a Dolphin MemoryCardA.USA.raw
+type memoryCard struct {
+	data []byte
+	// dir and bat the offsets of the active copies of the directory and the BAT
+	dir int
+	bat int
+}
+
+// Parse a raw memory card image, using the copies of the directory and BAT that were written last.
+func parseMemoryCard(data []byte) (*memoryCard, error) {
+	if len(data) < cardFirstBlock*cardBlockSize || len(data)%cardBlockSize != 0 {
+		return nil, errors.New("not a GameCube memory card")
+	}
+	card := &memoryCard{data: data}
+	var err error
+	card.dir, err = card.activeBlock(cardDirBlock, cardDirCounter, 0, cardDirChecksum, cardDirChecksum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory: %s", err.Error())
+	}
+	card.bat, err = card.activeBlock(cardBATBlock, cardBATCounter, cardBATCounter, cardBlockSize, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid block allocation table: %s", err.Error())
+	}
+	return card, nil
+}
+
+// Get the offset of the copy of a block with a valid checksum and the highest update counter.
+func (c *memoryCard) activeBlock(block int, counter int, start int, end int, checksum int) (int, error) {
+	active := -1
+	for _, offset := range []int{block * cardBlockSize, (block + 1) * cardBlockSize} {
+		sum, inverse := cardChecksums(c.data[offset+start : offset+end])
+		if binary.BigEndian.Uint16(c.data[offset+checksum:]) != sum || binary.BigEndian.Uint16(c.data[offset+checksum+2:]) != inverse {
+			continue
+		}
+		if active < 0 || int16(binary.BigEndian.Uint16(c.data[offset+counter:])-binary.BigEndian.Uint16(c.data[active+counter:])) > 0 {
+			active = offset
+		}
+	}
+	if active < 0 {
+		return 0, errors.New("checksum mismatch")
+	}
+	return active, nil
+}
+
+// Calculate the checksums of a directory or BAT the way the GameCube does.
+func cardChecksums(data []byte) (uint16, uint16) {
+	var sum, inverse uint16
+	for i := 0; i+1 < len(data); i += 2 {
+		word := binary.BigEndian.Uint16(data[i:])
+		sum += word
+		inverse += word ^ 0xFFFF
+	}
+	if sum == 0xFFFF {
+		sum = 0
+	}
+	if inverse == 0xFFFF {
+		inverse = 0
+	}
+	return sum, inverse
+}
+
+// Get the directory entries of the saves of a game, by the first 4 characters of its game ID.
+func (c *memoryCard) saves(gameCode string) []int {
+	var entries []int
+	for i := 0; i < cardEntries; i++ {
+		entry := c.data[c.dir+i*cardEntrySize:]
+		if string(entry[:4]) == gameCode {
+			entries = append(entries, i)
+		}
+	}
+	return entries
+}
+
+// Get the blocks of a save by following its chain in the BAT.
+func (c *memoryCard) blocks(entry int) ([]int, error) {
+	dirEntry := c.data[c.dir+entry*cardEntrySize:]
+	block := int(binary.BigEndian.Uint16(dirEntry[cardEntryFirst:]))
+	count := int(binary.BigEndian.Uint16(dirEntry[cardEntryBlocks:]))
+	var blocks []int
+	for len(blocks) < count {
+		if block < cardFirstBlock || (block+1)*cardBlockSize > len(c.data) {
+			return nil, fmt.Errorf("save %d has an invalid block %d", entry, block)
+		}
+		blocks = append(blocks, block)
+		block = int(binary.BigEndian.Uint16(c.data[c.bat+cardBATMap+(block-cardFirstBlock)*2:]))
+	}
+	return blocks, nil
+}
+
+// Get the data of a save, without its directory entry.
+func (c *memoryCard) read(entry int) ([]byte, error) {
+	blocks, err := c.blocks(entry)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, c.data[block*cardBlockSize:(block+1)*cardBlockSize]...)
+	}
+	return data, nil
+}
+
+// Replace the data of a save with data of the same number of blocks.
+func (c *memoryCard) write(entry int, data []byte) error {
+	blocks, err := c.blocks(entry)
+	if err != nil {
+		return err
+	}
+	if len(data) != len(blocks)*cardBlockSize {
+		return fmt.Errorf("expected %d blocks of save data but got %d bytes", len(blocks), len(data))
+	}
+	for i, block := range blocks {
+		copy(c.data[block*cardBlockSize:(block+1)*cardBlockSize], data[i*cardBlockSize:])
+	}
+	return nil
+}
+
+// Delete a save, freeing its blocks.
+func (c *memoryCard) delete(entry int) error {
+	blocks, err := c.blocks(entry)
+	if err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		binary.BigEndian.PutUint16(c.data[c.bat+cardBATMap+(block-cardFirstBlock)*2:], 0)
+	}
+	free := binary.BigEndian.Uint16(c.data[c.bat+cardBATFreeBlocks:])
+	binary.BigEndian.PutUint16(c.data[c.bat+cardBATFreeBlocks:], free+uint16(len(blocks)))
+	dirEntry := c.data[c.dir+entry*cardEntrySize : c.dir+(entry+1)*cardEntrySize]
+	for i := range dirEntry {
+		dirEntry[i] = 0xFF
+	}
+	c.commit()
+	return nil
+}
+
+// Add a save with its directory entry, e.g. one read from another card, allocating free blocks
+// for its data.
+func (c *memoryCard) add(dirEntry []byte, data []byte) error {
+	if len(data) == 0 || len(data)%cardBlockSize != 0 {
+		return fmt.Errorf("save data of %d bytes isn't a whole number of blocks", len(data))
+	}
+	entry := -1
+	for i := 0; i < cardEntries && entry < 0; i++ {
+		if binary.BigEndian.Uint32(c.data[c.dir+i*cardEntrySize:]) == 0xFFFFFFFF {
+			entry = i
+		}
+	}
+	if entry < 0 {
+		return errors.New("the memory card has no free directory entry")
+	}
+	var blocks []int
+	for block := cardFirstBlock; block < len(c.data)/cardBlockSize && len(blocks) < len(data)/cardBlockSize; block++ {
+		if binary.BigEndian.Uint16(c.data[c.bat+cardBATMap+(block-cardFirstBlock)*2:]) == 0 {
+			blocks = append(blocks, block)
+		}
+	}
+	if len(blocks) < len(data)/cardBlockSize {
+		return fmt.Errorf("the memory card has %d free blocks but the save needs %d", len(blocks), len(data)/cardBlockSize)
+	}
+	for i, block := range blocks {
+		next := cardLastBlock
+		if i+1 < len(blocks) {
+			next = blocks[i+1]
+		}
+		binary.BigEndian.PutUint16(c.data[c.bat+cardBATMap+(block-cardFirstBlock)*2:], uint16(next))
+		copy(c.data[block*cardBlockSize:(block+1)*cardBlockSize], data[i*cardBlockSize:])
+	}
+	free := binary.BigEndian.Uint16(c.data[c.bat+cardBATFreeBlocks:])
+	binary.BigEndian.PutUint16(c.data[c.bat+cardBATFreeBlocks:], free-uint16(len(blocks)))
+	binary.BigEndian.PutUint16(c.data[c.bat+cardBATLastAlloc:], uint16(blocks[len(blocks)-1]))
+	newEntry := c.data[c.dir+entry*cardEntrySize : c.dir+(entry+1)*cardEntrySize]
+	copy(newEntry, dirEntry)
+	binary.BigEndian.PutUint16(newEntry[cardEntryFirst:], uint16(blocks[0]))
+	binary.BigEndian.PutUint16(newEntry[cardEntryBlocks:], uint16(len(blocks)))
+	c.commit()
+	return nil
+}
+
+// Write the changed directory and BAT to both of their copies with a new update counter and
+// checksums, so that the card stays valid whichever copy is read.
+func (c *memoryCard) commit() {
+	counter := binary.BigEndian.Uint16(c.data[c.dir+cardDirCounter:]) + 1
+	binary.BigEndian.PutUint16(c.data[c.dir+cardDirCounter:], counter)
+	sum, inverse := cardChecksums(c.data[c.dir : c.dir+cardDirChecksum])
+	binary.BigEndian.PutUint16(c.data[c.dir+cardDirChecksum:], sum)
+	binary.BigEndian.PutUint16(c.data[c.dir+cardDirChecksum+2:], inverse)
+
+	counter = binary.BigEndian.Uint16(c.data[c.bat+cardBATCounter:]) + 1
+	binary.BigEndian.PutUint16(c.data[c.bat+cardBATCounter:], counter)
+	sum, inverse = cardChecksums(c.data[c.bat+cardBATCounter : c.bat+cardBlockSize])
+	binary.BigEndian.PutUint16(c.data[c.bat:], sum)
+	binary.BigEndian.PutUint16(c.data[c.bat+2:], inverse)
+
+	for _, copyOf := range []struct{ active, block int }{{c.dir, cardDirBlock}, {c.bat, cardBATBlock}} {
+		for _, offset := range []int{copyOf.block * cardBlockSize, (copyOf.block + 1) * cardBlockSize} {
+			if offset != copyOf.active {
+				copy(c.data[offset:offset+cardBlockSize], c.data[copyOf.active:copyOf.active+cardBlockSize])
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+/*
+	minisign (https://jedisct1.github.io/minisign/) public keys and signatures, which are
+	ed25519 keys and signatures with a key ID. Signatures made with "minisign -S" sign the
+	BLAKE2b-512 hash of the file, signatures made with "minisign -S -l" sign the file itself.
+*/
+
+// minisignKey a minisign public key
+type minisignKey struct {
+	keyID []byte
+	key   ed25519.PublicKey
+}
+
+// Parse a minisign public key, either the whole .pub file or just the base64 line of it.
+func parseMinisignKey(text string) (minisignKey, error) {
+	var encoded string
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			encoded = line
+		}
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != "Ed" {
+		return minisignKey{}, errors.New("invalid minisign public key")
+	}
+	return minisignKey{keyID: data[2:10], key: ed25519.PublicKey(data[10:])}, nil
+}
+
+// Verify a minisign signature (the contents of a .minisig file) of a message.
+func verifyMinisign(key minisignKey, message []byte, signature string) error {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(signature), "\r\n", "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid minisign signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	algorithm, keyID, sig := string(sig[:2]), sig[2:10], sig[10:]
+	if !bytes.Equal(keyID, key.keyID) {
+		return fmt.Errorf("signed with key %X instead of key %X", reverse(keyID), reverse(key.keyID))
+	}
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		hash := blake2b.Sum512(message)
+		message = hash[:]
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %s", algorithm)
+	}
+	if !ed25519.Verify(key.key, message, sig) {
+		return errors.New("signature does not match")
+	}
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key.key, append(sig, trustedComment...), globalSig) {
+		return errors.New("trusted comment signature does not match")
+	}
+	return nil
+}
+
+// Key IDs are stored little-endian but printed by minisign as a big-endian number.
+func reverse(data []byte) []byte {
+	reversed := make([]byte, len(data))
+	for i := range data {
+		reversed[len(data)-1-i] = data[i]
+	}
+	return reversed
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Create a minisign public key and signature of a message like minisign would.
+func signMinisign(t *testing.T, algorithm string, message []byte) (string, string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	check(err)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	key := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), publicKey...))
+	signed := message
+	if algorithm == "ED" {
+		hash := blake2b.Sum512(message)
+		signed = hash[:]
+	}
+	sig := ed25519.Sign(privateKey, signed)
+	trustedComment := "timestamp:1700000000\tfile:SHA256SUMS"
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig...), trustedComment...))
+	signature := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), sig...)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n"
+	return "untrusted comment: minisign public key 0807060504030201\n" + key + "\n", signature
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	message := []byte("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef  patches.zip\n")
+	for _, algorithm := range []string{"Ed", "ED"} {
+		publicKey, signature := signMinisign(t, algorithm, message)
+		key, err := parseMinisignKey(publicKey)
+		if err != nil {
+			t.Fatalf("Failed to parse public key: %s", err.Error())
+		}
+		if err := verifyMinisign(key, message, signature); err != nil {
+			t.Fatalf("Failed to verify %s signature: %s", algorithm, err.Error())
+		}
+		if err := verifyMinisign(key, append(message, '!'), signature); err == nil {
+			t.Fatalf("Verified %s signature of a tampered message", algorithm)
+		}
+		otherKey, _ := signMinisign(t, algorithm, message)
+		key, err = parseMinisignKey(otherKey)
+		check(err)
+		if err := verifyMinisign(key, message, signature); err == nil {
+			t.Fatalf("Verified %s signature with the wrong key", algorithm)
+		}
+	}
+}
+
+func TestChecksumsVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checksums")
+	check(err)
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "patch.xdelta")
+	check(ioutil.WriteFile(filePath, []byte("abc"), 0644))
+
+	sums := parseChecksums("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad *patch.xdelta\n" +
+		"0000000000000000000000000000000000000000000000000000000000000000  patches.zip\r\n" +
+		"BA7816BF8F01CFEA414140DE5DAE2223B00361A396177A9CB410FF61F20015AD  SCON4 patch v1.0.xdelta\n" +
+		"not a hash  patch.xdelta\n")
+	if err := sums.verify("patch.xdelta", filePath); err != nil {
+		t.Fatalf("Failed to verify checksum: %s", err.Error())
+	}
+	if err := sums.verify("patches.zip", filePath); err == nil {
+		t.Fatal("Verified a file that does not match its checksum")
+	}
+	if err := sums.verify("SCON4 patch v1.0.xdelta", filePath); err != nil {
+		t.Fatalf("Failed to verify the checksum of a file name with spaces: %s", err.Error())
+	}
+	if len(sums) != 3 {
+		t.Errorf("Expected 3 checksums but got %v", sums)
+	}
+	if err := sums.verify("vanilla.xdelta", filePath); err == nil {
+		t.Fatal("Verified a file that is not listed in the checksums")
+	}
+	if err := checksums(nil).verify("patch.xdelta", filePath); err != nil {
+		t.Fatal("Expected no verification without checksums")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// probeTimeout how long to wait for a mirror to answer before it is tried last
+var probeTimeout = 3 * time.Second
+
+// Get the url of an asset on a mirror. The base url may contain {version} and {asset}, otherwise
+// they are appended as {base}/{version}/{asset}.
+func mirrorURL(base string, version string, asset string) string {
+	if strings.Contains(base, "{asset}") {
+		base = strings.ReplaceAll(base, "{version}", url.PathEscape(version))
+		return strings.ReplaceAll(base, "{asset}", url.PathEscape(asset))
+	}
+	return strings.TrimSuffix(base, "/") + "/" + url.PathEscape(version) + "/" + url.PathEscape(asset)
+}
+
+// Add the mirrors of the config to every asset of the releases.
+func addMirrors(tags []Tag, mirrors []string) {
+	for i := range tags {
+		for j := range tags[i].Assets {
+			asset := &tags[i].Assets[j]
+			for _, mirror := range mirrors {
+				asset.Mirrors = append(asset.Mirrors, mirrorURL(mirror, tags[i].Version, asset.Name))
+			}
+		}
+	}
+}
+
+// verifyError a downloaded file that doesn't pass verification
+type verifyError struct {
+	err error
+}
+
+func (e *verifyError) Error() string {
+	return e.err.Error()
+}
+
+// Download a file from the first of the urls that works, trying the fastest to answer first. If
+// verify is given, a file that doesn't pass it is deleted and the next url is tried; the error
+// is a verifyError if the last url failed verification.
+func downloadFromMirrors(urls []string, filePath string, expectedSize int64, verify func(string) error) error {
+	if len(urls) > 1 {
+		urls = rankMirrors(urls)
+	}
+	var err error
+	for i, downloadURL := range urls {
+		if i > 0 {
+			fmt.Printf("Download failed: %s\nTrying mirror %s\n", err.Error(), downloadURL)
+		}
+		err = download(downloadURL, filePath, expectedSize)
+		if err == nil && verify != nil {
+			if verifyErr := verify(filePath); verifyErr != nil {
+				os.Remove(filePath)
+				err = &verifyError{err: verifyErr}
+			}
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Order urls by how fast they answer a HEAD request. Unreachable urls are moved to the end
+// rather than left out, since a probe can fail where a download would not.
+func rankMirrors(urls []string) []string {
+	latencies := make([]time.Duration, len(urls))
+	// Create the shared client before probing concurrently
+	httpClient()
+	done := make(chan struct{})
+	for i := range urls {
+		go func(i int) {
+			latencies[i] = probe(urls[i])
+			done <- struct{}{}
+		}(i)
+	}
+	for range urls {
+		<-done
+	}
+	ranked := make([]string, len(urls))
+	copy(ranked, urls)
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return latencies[order[a]] < latencies[order[b]]
+	})
+	for i, index := range order {
+		ranked[i] = urls[index]
+	}
+	return ranked
+}
+
+// Measure how long a url takes to answer a HEAD request, or the longest duration if it fails.
+func probe(probeURL string) time.Duration {
+	const unreachable = time.Duration(1<<63 - 1)
+	if strings.HasPrefix(probeURL, "file:") {
+		if path, err := localFile(probeURL); err == nil && exists(path) {
+			return 0
+		}
+		return unreachable
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", probeURL, nil)
+	if err != nil {
+		return unreachable
+	}
+	start := time.Now()
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return unreachable
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return unreachable
+	}
+	return time.Since(start)
+}
+
+// Parse a comma separated list of mirror base urls.
+func parseMirrors(value string) ([]string, error) {
+	var mirrors []string
+	for _, mirror := range strings.Split(value, ",") {
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" {
+			continue
+		}
+		parsed, err := url.Parse(mirror)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" && parsed.Scheme != "file" {
+			return nil, errors.New("expected an http, https or file url: " + mirror)
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test the urls of assets on mirrors with and without placeholders.
+func TestMirrorURL(t *testing.T) {
+	expected := map[string]string{
+		"https://cdn.example.com/scon4/":                          "https://cdn.example.com/scon4/v1.2.0/patches.zip",
+		"http://127.0.0.1:8080/ipns/scon4/{version}-{asset}":      "http://127.0.0.1:8080/ipns/scon4/v1.2.0-patches.zip",
+		"https://example.com/download?file={asset}&tag={version}": "https://example.com/download?file=patches.zip&tag=v1.2.0",
+	}
+	for base, url := range expected {
+		if actual := mirrorURL(base, "v1.2.0", "patches.zip"); actual != url {
+			t.Errorf("Expected %s for %s but got %s", url, base, actual)
+		}
+	}
+}
+
+// Test that the fastest mirror is tried first and that a failing url falls back to the next one.
+func TestDownloadFromMirrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "patch")
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "patch")
+	}))
+	defer fast.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer broken.Close()
+
+	ranked := rankMirrors([]string{slow.URL, broken.URL, fast.URL})
+	if ranked[0] != fast.URL || ranked[1] != slow.URL {
+		t.Errorf("Expected the fast mirror first and the broken mirror last but got %v", ranked)
+	}
+
+	output := filepath.Join(t.TempDir(), "patch.xdelta")
+	err := downloadFromMirrors([]string{broken.URL, fast.URL}, output, 5, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "patch" {
+		t.Errorf("Unexpected download %q", data)
+	}
+	err = downloadFromMirrors([]string{broken.URL, broken.URL + "/mirror"}, output, 5, nil)
+	if err == nil {
+		t.Error("Expected the download to fail when every mirror fails")
+	}
+}
+
+// Test that a mirror whose file doesn't match its checksum is skipped for the next one.
+func TestDownloadFromMirrorsVerifies(t *testing.T) {
+	tampered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "evil!")
+	}))
+	defer tampered.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "patch")
+	}))
+	defer good.Close()
+	output := filepath.Join(t.TempDir(), "patch.xdelta")
+	check(os.WriteFile(output, []byte("patch"), 0644))
+	actual, err := sha256File(output)
+	check(err)
+	sums := checksums{"patch.xdelta": actual}
+	verify := func(path string) error { return sums.verify("patch.xdelta", path) }
+
+	if err := downloadFromMirrors([]string{tampered.URL, good.URL}, output, 5, verify); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "patch" {
+		t.Errorf("Expected the file of the mirror that matches but got %q", data)
+	}
+	err = downloadFromMirrors([]string{tampered.URL, tampered.URL + "/mirror"}, output, 5, verify)
+	var verifyErr *verifyError
+	if !errors.As(err, &verifyErr) || exists(output) {
+		t.Errorf("Expected a verify error and no file when no mirror matches but got %v", err)
+	}
+}
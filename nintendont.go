@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NintendontFormats the formats a game can be written in for Nintendont
+var NintendontFormats = []string{"iso", "ciso"}
+
+// argNintendontDir the SD card or USB drive given as argument to also write the patched ISO to
+var argNintendontDir string
+
+// argNintendontFormat the format given as argument to write the game for Nintendont in
+var argNintendontFormat string
+
+// Get the format to write the game for Nintendont in, preferring the argument over the config.
+func nintendontFormat() string {
+	format := argNintendontFormat
+	if format == "" {
+		format = config.NintendontFormat
+	}
+	if format == "" {
+		return NintendontFormats[0]
+	}
+	for _, known := range NintendontFormats {
+		if format == known {
+			return format
+		}
+	}
+	fail(ExitUsage, "Unknown Nintendont format %s, expected one of %s", format, strings.Join(NintendontFormats, ", "))
+	return ""
+}
+
+// Get the path Nintendont loads a game from, e.g. games/SCON4 [G4NJDA]/game.iso
+func nintendontPath(dir string, title string, gameID string, format string) string {
+	return filepath.Join(dir, "games", fatFileName(title+" ["+gameID+"]"), "game."+format)
+}
+
+// Replace the characters FAT32 doesn't allow in file names.
+func fatFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`"*/:<>?\|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.TrimRight(name, ". ")
+}
+
+// Get the SD card or USB drive to write the game to for Nintendont, preferring the argument over
+// the config. Empty if the game isn't written for Nintendont.
+func nintendontDir() string {
+	if argNintendontDir != "" {
+		return argNintendontDir
+	}
+	return config.NintendontDir
+}
+
+// Fail before updating if the drive to write the game to for Nintendont isn't there.
+func checkNintendontDir() {
+	if dir := nintendontDir(); dir != "" && !exists(dir) {
+		fail(ExitUsage, "%s doesn't exist, insert the SD card or USB drive or set another one with -nintendont", dir)
+	}
+}
+
+// nintendontGame writes the patched game to the games folder of a drive for Nintendont as it is
+// patched. The folder is only known once the game ID at the start of the game is. A drive that is
+// removed or full by now is only reported, so that the patched ISO is still installed: the first
+// error stops the write and the rest of the game is ignored.
+type nintendontGame struct {
+	dir      string
+	format   string
+	size     int64
+	header   []byte
+	target   string
+	partPath string
+	file     *os.File
+	image    imageWriter
+	err      error
+}
+
+// Start writing the patched game for Nintendont, if a drive is given as argument or in the config.
+// Returns nil if the game isn't written for Nintendont.
+func startNintendont(patchPath string) *nintendontGame {
+	dir := nintendontDir()
+	if dir == "" {
+		return nil
+	}
+	size, err := xdeltaTargetSize(patchPath)
+	if err != nil {
+		// Patching reports an invalid patch
+		return nil
+	}
+	fmt.Printf("\nAlso writing the game for Nintendont to %s\n", dir)
+	return &nintendontGame{dir: dir, format: nintendontFormat(), size: size}
+}
+
+// Write the next part of the patched game, never failing so that the patched ISO is still written.
+func (g *nintendontGame) Write(p []byte) (int, error) {
+	if g.err != nil {
+		return len(p), nil
+	}
+	if g.image == nil {
+		g.header = append(g.header, p...)
+		if len(g.header) < 6 && int64(len(g.header)) < g.size {
+			return len(p), nil
+		}
+		gameID := g.header
+		if len(gameID) > 6 {
+			gameID = gameID[:6]
+		}
+		if g.err = g.open(string(gameID)); g.err != nil {
+			return len(p), nil
+		}
+		p = g.header
+	}
+	_, g.err = g.image.Write(p)
+	return len(p), nil
+}
+
+// Create the game next to the game already in its folder, so that a failed write leaves the old
+// game in place.
+func (g *nintendontGame) open(gameID string) error {
+	if !exists(g.dir) {
+		return fmt.Errorf("%s doesn't exist", g.dir)
+	}
+	g.target = nintendontPath(g.dir, profile.ModName, gameID, g.format)
+	g.partPath = g.target + ".part"
+	if err := volumeProblem(g.partPath, maxImageSize(g.format, g.size)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.target), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(g.partPath)
+	if err != nil {
+		return err
+	}
+	g.file = file
+	g.image, err = newImageWriter(file, g.format, g.size)
+	return err
+}
+
+// Stop writing the game and remove what was written of it.
+func (g *nintendontGame) abort() {
+	if g == nil || g.file == nil {
+		return
+	}
+	g.file.Close()
+	os.Remove(g.partPath)
+	g.file = nil
+}
+
+// Finish the game and replace the game already in its folder in either format. Returns the path
+// of the game.
+func (g *nintendontGame) finish() (string, error) {
+	if g.err == nil && g.image == nil {
+		g.err = fmt.Errorf("expected a %d byte image but got %d bytes", g.size, len(g.header))
+	}
+	if g.err == nil {
+		g.err = g.image.finish()
+	}
+	if g.err == nil {
+		g.err = g.file.Close()
+	}
+	if g.err == nil {
+		g.err = os.Rename(g.partPath, g.target)
+	}
+	if g.err != nil {
+		g.abort()
+		return "", g.err
+	}
+	// A game in the other format would be loaded instead of the new one
+	for _, known := range NintendontFormats {
+		if previous := filepath.Join(filepath.Dir(g.target), "game."+known); previous != g.target && exists(previous) {
+			os.Remove(previous)
+		}
+	}
+	return g.target, nil
+}
+
+// Report whether the game was written for Nintendont.
+func writeNintendont(g *nintendontGame) {
+	if g == nil {
+		return
+	}
+	target, err := g.finish()
+	if err != nil {
+		fmt.Printf("Unable to write the game for Nintendont to %s: %s\n", g.dir, err.Error())
+		return
+	}
+	fmt.Println("Saved for Nintendont to " + target)
+	emit(Event{Event: "nintendont", Path: target})
+}
+
+// nintendontOutput passes the patched data on to the game for Nintendont as it is written to the
+// image.
+type nintendontOutput struct {
+	imageWriter
+	game *nintendontGame
+}
+
+func (o nintendontOutput) Write(p []byte) (int, error) {
+	n, err := o.imageWriter.Write(p)
+	o.game.Write(p[:n])
+	return n, err
+}
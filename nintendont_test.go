@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that a CISO leaves out the empty blocks and keeps the data of the others.
+func TestWriteCISO(t *testing.T) {
+	blockSize := int64(0x10)
+	image := make([]byte, 0x38)
+	copy(image, "G4NJDA")
+	image[0x31] = 0xFF
+	_, output := testImage(t)
+	writer, err := newCISOWriter(output, int64(len(image)), blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write(image[:0x15])
+	writer.Write(image[0x15:])
+	if err := writer.finish(); err != nil {
+		t.Fatal(err)
+	}
+	data := readAll(t, output)
+	if len(data) != CISOHeaderSize+0x20 {
+		t.Fatalf("Unexpected CISO size %d", len(data))
+	}
+	if string(data[:4]) != "CISO" || binary.LittleEndian.Uint32(data[4:]) != uint32(blockSize) {
+		t.Error("Unexpected CISO header")
+	}
+	if !bytes.Equal(data[8:12], []byte{1, 0, 0, 1}) {
+		t.Errorf("Unexpected block map %v", data[8:12])
+	}
+	if string(data[CISOHeaderSize:CISOHeaderSize+6]) != "G4NJDA" || data[CISOHeaderSize+0x11] != 0xFF {
+		t.Error("Expected the data of the used blocks")
+	}
+}
+
+// Test the games folder layout Nintendont expects.
+func TestNintendontPath(t *testing.T) {
+	path := nintendontPath("E:", "SCON4: Beta?", "G4NJDA", "ciso")
+	if expected := filepath.Join("E:", "games", "SCON4_ Beta_ [G4NJDA]", "game.ciso"); path != expected {
+		t.Errorf("Expected %s but got %s", expected, path)
+	}
+}
+
+// Write a patched game for Nintendont in two parts, the first too short to hold the game ID.
+func writeNintendontGame(image []byte, drive string, format string) (string, error) {
+	game := &nintendontGame{dir: drive, format: format, size: int64(len(image))}
+	game.Write(image[:4])
+	game.Write(image[4:])
+	return game.finish()
+}
+
+// Test that a game replaces the one already on the drive in either format, and that a failed
+// write leaves the old game in place.
+func TestWriteNintendontGame(t *testing.T) {
+	applyProfile(builtinProfiles[0])
+	dir := t.TempDir()
+	image := make([]byte, 0x40)
+	copy(image, "G4NJDA")
+
+	drive := filepath.Join(dir, "sd")
+	old := nintendontPath(drive, profile.ModName, "G4NJDA", "ciso")
+	check(os.MkdirAll(filepath.Dir(old), 0755))
+	check(ioutil.WriteFile(old, []byte("old"), 0644))
+
+	// The .part file can't be created where a folder is in the way
+	partPath := nintendontPath(drive, profile.ModName, "G4NJDA", "iso") + ".part"
+	check(os.Mkdir(partPath, 0755))
+	if _, err := writeNintendontGame(image, drive, "iso"); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	if !exists(old) {
+		t.Fatal("Expected the old game to be kept when the write fails")
+	}
+	check(os.Remove(partPath))
+
+	target, err := writeNintendontGame(image, drive, "iso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written, _ := ioutil.ReadFile(target); !bytes.Equal(written, image) {
+		t.Error("Expected the patched ISO to be written")
+	}
+	if exists(old) || exists(target+".part") {
+		t.Error("Expected the old game and the .part file to be removed")
+	}
+	if _, err := writeNintendontGame(image, filepath.Join(dir, "missing"), "iso"); err == nil {
+		t.Error("Expected an error for a drive that doesn't exist")
+	}
+}
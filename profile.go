@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ProfilesFile optional file declaring additional base game and mod profiles
+var ProfilesFile = "data/profiles.json"
+
+// argProfile name of the profile given as argument
+var argProfile string
+
+// profile the currently selected base game and mod profile
+var profile Profile
+
+// Offset an offset into a disc image. In profile files it may be written either as a
+// number or as a string such as "0x248104" to keep it readable.
+type Offset int64
+
+func (o *Offset) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		var number int64
+		if err := json.Unmarshal(data, &number); err != nil {
+			return err
+		}
+		*o = Offset(number)
+		return nil
+	}
+	number, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		return err
+	}
+	*o = Offset(number)
+	return nil
+}
+
+// ByteWrite bytes (as a hex string) to write at an offset of a disc image
+type ByteWrite struct {
+	Offset Offset `json:"offset"`
+	Bytes  string `json:"bytes"`
+}
+
+// ByteRange the bytes from Start up to but not including End of a disc image
+type ByteRange struct {
+	Start Offset `json:"start"`
+	End   Offset `json:"end"`
+}
+
+// NormalizeRule how to convert a known dump of the base game into the dump the patches expect.
+// The converter is run first (if any), then the writes, then the zeroed ranges.
+type NormalizeRule struct {
+	Name      string      `json:"name"`
+	CRC32     string      `json:"crc32"`
+	Converter string      `json:"converter"`
+	Writes    []ByteWrite `json:"writes"`
+	Zeroes    []ByteRange `json:"zeroes"`
+}
+
+// Profile a base game and the mod that is patched on top of it
+type Profile struct {
+	Name       string          `json:"name"`
+	GameName   string          `json:"game_name"`
+	ModName    string          `json:"mod_name"`
+	GameID     string          `json:"game_id"`
+	Hashes     []string        `json:"hashes"`
+	Normalize  []NormalizeRule `json:"normalize"`
+	Repository string          `json:"repository"`
+	PublicKey  string          `json:"public_key"`
+	OutputName string          `json:"output_name"`
+}
+
+// converter a built-in dump converter that a NormalizeRule can reference by name
+type converter struct {
+	matches func(filePath string) bool
+	convert func(filePath string) []byte
+}
+
+var converters = map[string]converter{
+	"gnt4-nkit": {matches: isNkit, convert: convertNkitToIso},
+	"gnt4-ciso": {matches: isCISO, convert: patchCISO},
+}
+
+// The built-in profiles, the first of which is the default
+var builtinProfiles = []Profile{
+	{
+		Name:     "scon4",
+		GameName: "GNT4",
+		ModName:  "SCON4",
+		GameID:   "G4NJDA",
+		// CRC32 of the "bad" dump that pads with zeroes instead of random bytes
+		Hashes: []string{"55ee8b1a"},
+		Normalize: []NormalizeRule{
+			// 60aefa3e is the CRC32 hash of both a good ISO dump AND an Nkit ISO somehow
+			{Name: "NKIT", CRC32: "60aefa3e", Converter: "gnt4-nkit"},
+			{
+				Name:  "good dump",
+				CRC32: "60aefa3e",
+				// This weird four byte word in bi2.bin
+				Writes: []ByteWrite{{Offset: 0x500, Bytes: "00520202"}},
+				// Random padding bytes replaced with zeroes
+				Zeroes: []ByteRange{{Start: 0x248104, End: 0xC4F8000}, {Start: 0x4553001C, End: 0x45532B80}},
+			},
+			{Name: "CISO", CRC32: "0371b18c", Converter: "gnt4-ciso"},
+		},
+		Repository: "https://api.github.com/repos/NicholasMoser/SCON4-Releases/releases",
+		OutputName: "SCON4-{version}.iso",
+	},
+}
+
+// Load the built-in profiles followed by any declared in the profiles file. A profile in
+// the profiles file replaces a built-in profile of the same name.
+func loadProfiles() []Profile {
+	profiles := make([]Profile, len(builtinProfiles))
+	copy(profiles, builtinProfiles)
+	if !exists(ProfilesFile) {
+		return profiles
+	}
+	var declared []Profile
+	err := json.Unmarshal([]byte(readFile(ProfilesFile)), &declared)
+	if err != nil {
+		fail(ExitFailure, "Unable to read %s: %s", ProfilesFile, err.Error())
+	}
+	for _, p := range declared {
+		if err := validateProfile(p); err != nil {
+			fail(ExitFailure, "Invalid profile in %s: %s", ProfilesFile, err.Error())
+		}
+		replaced := false
+		for i := range profiles {
+			if profiles[i].Name == p.Name {
+				profiles[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			profiles = append(profiles, p)
+		}
+	}
+	return profiles
+}
+
+// Return an error describing the first problem with a profile, if any.
+func validateProfile(p Profile) error {
+	if p.Name == "" {
+		return fmt.Errorf("profile is missing a name")
+	}
+	if len(p.GameID) != 6 {
+		return fmt.Errorf("%s: game_id must be six characters", p.Name)
+	}
+	if len(p.Hashes) == 0 {
+		return fmt.Errorf("%s: at least one accepted hash is required", p.Name)
+	}
+	if p.Repository == "" {
+		return fmt.Errorf("%s: repository is required", p.Name)
+	}
+	if !strings.Contains(p.OutputName, "{version}") {
+		return fmt.Errorf("%s: output_name must contain {version}", p.Name)
+	}
+	for _, rule := range p.Normalize {
+		if _, ok := converters[rule.Converter]; rule.Converter != "" && !ok {
+			return fmt.Errorf("%s: unknown converter %s", p.Name, rule.Converter)
+		}
+		for _, write := range rule.Writes {
+			data, err := hex.DecodeString(write.Bytes)
+			if err != nil {
+				return fmt.Errorf("%s: invalid bytes %s", p.Name, write.Bytes)
+			}
+			if write.Offset < 0 || int64(write.Offset)+int64(len(data)) > GameCubeDiscSize {
+				return fmt.Errorf("%s: write at %#x is outside of a GameCube disc", p.Name, int64(write.Offset))
+			}
+		}
+		for _, zeroes := range rule.Zeroes {
+			if zeroes.Start < 0 || zeroes.Start > zeroes.End || int64(zeroes.End) > GameCubeDiscSize {
+				return fmt.Errorf("%s: zeroes from %#x to %#x must be an ascending range within a GameCube disc",
+					p.Name, int64(zeroes.Start), int64(zeroes.End))
+			}
+		}
+	}
+	return nil
+}
+
+// Select the profile to use, either by name from the arguments or by detecting the
+// game ID of the given ISOs. Otherwise the default profile is used.
+func selectProfile(isoPaths ...string) {
+	profiles := loadProfiles()
+	if argProfile != "" {
+		for _, p := range profiles {
+			if p.Name == argProfile {
+				applyProfile(p)
+				return
+			}
+		}
+		fmt.Println("Available profiles:")
+		for _, p := range profiles {
+			fmt.Printf("  - %s (%s for %s)\n", p.Name, p.ModName, p.GameName)
+		}
+		fail(ExitUsage, "Unknown profile: %s", argProfile)
+	}
+	for _, isoPath := range isoPaths {
+		if isoPath == "" || !exists(isoPath) {
+			continue
+		}
+		gameID := readGameID(isoPath)
+		for _, p := range profiles {
+			if p.GameID == gameID {
+				applyProfile(p)
+				return
+			}
+		}
+	}
+	applyProfile(profiles[0])
+}
+
+// Make the given profile the current one. Every profile other than the default keeps its
+// data files in its own folder so that several mods can be updated side by side.
+func applyProfile(p Profile) {
+	profile = p
+	GitRepository = p.Repository
+	if p.Name != builtinProfiles[0].Name {
+		setDataDir(filepath.Join("data", p.Name))
+	}
+}
+
+// Point every data file at the given data directory.
+func setDataDir(dir string) {
+	DATA = dir
+	ConfigFile = filepath.Join(dir, "config.json")
+	PatchFile = filepath.Join(dir, TempDir, "patch.xdelta")
+	PatchZip = filepath.Join(dir, TempDir, "patch.zip")
+	GNT4ISO = filepath.Join(dir, profile.GameName+".iso")
+}
+
+// Get the name of the output ISO for a version of the mod.
+func outputName(version string) string {
+	return strings.ReplaceAll(profile.OutputName, "{version}", version)
+}
+
+// Read the six character game ID of a disc image, looking past the header of a CISO.
+func readGameID(filePath string) string {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	data := make([]byte, 6)
+	n, _ := f.ReadAt(data, 0)
+	if n == len(data) && string(data[:4]) == "CISO" {
+		n, _ = f.ReadAt(data, CISOHeaderSize)
+	}
+	return string(data[:n])
+}
+
+// Check if a file is a CISO
+func isCISO(filePath string) bool {
+	f, err := os.Open(filePath)
+	check(err)
+	defer f.Close()
+	data := make([]byte, 4)
+	n, _ := f.ReadAt(data, 0)
+	return n == len(data) && string(data) == "CISO"
+}
+
+// Normalize a dump of the base game with the given rule and return the bytes.
+func normalize(rule NormalizeRule, filePath string) []byte {
+	needed := GameCubeDiscSize
+	if size := getFileSize(filePath); size > needed {
+		needed = size
+	}
+	checkMemory(needed, "convert "+rule.Name)
+	var isoBytes []byte
+	if rule.Converter != "" {
+		isoBytes = converters[rule.Converter].convert(filePath)
+	} else {
+		var err error
+		isoBytes, err = os.ReadFile(filePath)
+		check(err)
+	}
+	// The profile was validated against the size of a full disc, but the dump may be smaller
+	size := Offset(len(isoBytes))
+	for _, write := range rule.Writes {
+		data, err := hex.DecodeString(write.Bytes)
+		check(err)
+		if write.Offset+Offset(len(data)) > size {
+			fail(ExitFailure, "Unable to convert %s: the write at %#x is past the end of the %d byte dump", rule.Name, int64(write.Offset), size)
+		}
+		copy(isoBytes[write.Offset:], data)
+	}
+	for _, zeroes := range rule.Zeroes {
+		if zeroes.End > size {
+			fail(ExitFailure, "Unable to convert %s: the zeroes up to %#x are past the end of the %d byte dump", rule.Name, int64(zeroes.End), size)
+		}
+		for i := zeroes.Start; i < zeroes.End; i++ {
+			isoBytes[i] = 0
+		}
+	}
+	return isoBytes
+}
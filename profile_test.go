@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Get a valid profile to break in tests.
+func testProfile() Profile {
+	return Profile{
+		Name:       "test",
+		GameID:     "GTSTDA",
+		Hashes:     []string{"00000000"},
+		Repository: "https://example.com/releases",
+		OutputName: "Test-{version}.iso",
+		Normalize: []NormalizeRule{{
+			Name:   "test dump",
+			Writes: []ByteWrite{{Offset: 0x10, Bytes: "0102"}},
+			Zeroes: []ByteRange{{Start: 0x20, End: 0x30}},
+		}},
+	}
+}
+
+// Test that profiles with missing fields, unknown converters and writes or zeroes outside of a
+// disc are refused.
+func TestValidateProfile(t *testing.T) {
+	if err := validateProfile(testProfile()); err != nil {
+		t.Fatalf("Expected the profile to be valid but got %v", err)
+	}
+	for _, p := range builtinProfiles {
+		if err := validateProfile(p); err != nil {
+			t.Errorf("Expected the built-in profile %s to be valid but got %v", p.Name, err)
+		}
+	}
+	tests := map[string]func(p *Profile){
+		"no name":              func(p *Profile) { p.Name = "" },
+		"short game id":        func(p *Profile) { p.GameID = "GTST" },
+		"no hashes":            func(p *Profile) { p.Hashes = nil },
+		"no repository":        func(p *Profile) { p.Repository = "" },
+		"no version in output": func(p *Profile) { p.OutputName = "Test.iso" },
+		"unknown converter":    func(p *Profile) { p.Normalize[0].Converter = "wbfs" },
+		"invalid bytes":        func(p *Profile) { p.Normalize[0].Writes[0].Bytes = "xyz" },
+		"negative write":       func(p *Profile) { p.Normalize[0].Writes[0].Offset = -1 },
+		"write past the disc":  func(p *Profile) { p.Normalize[0].Writes[0].Offset = Offset(GameCubeDiscSize - 1) },
+		"negative zeroes":      func(p *Profile) { p.Normalize[0].Zeroes[0].Start = -1 },
+		"descending zeroes":    func(p *Profile) { p.Normalize[0].Zeroes[0] = ByteRange{Start: 0x30, End: 0x20} },
+		"zeroes past the disc": func(p *Profile) { p.Normalize[0].Zeroes[0].End = Offset(GameCubeDiscSize + 1) },
+	}
+	for name, change := range tests {
+		p := testProfile()
+		change(&p)
+		if err := validateProfile(p); err == nil {
+			t.Errorf("Expected a profile with %s to be refused", name)
+		}
+	}
+}
+
+// Test that the profile of an ISO is selected by its game ID, and the default one otherwise.
+func TestSelectProfileByGameID(t *testing.T) {
+	defer func() {
+		ProfilesFile = "data/profiles.json"
+		applyProfile(builtinProfiles[0])
+		setDataDir("data")
+	}()
+	dir := t.TempDir()
+	ProfilesFile = filepath.Join(dir, "profiles.json")
+	os.WriteFile(ProfilesFile, []byte(`[{"name": "test", "game_id": "GTSTDA", "hashes": ["00000000"],
+		"repository": "https://example.com/releases", "output_name": "Test-{version}.iso"}]`), 0644)
+	isoPath := filepath.Join(dir, "test.iso")
+	os.WriteFile(isoPath, []byte("GTSTDA"), 0644)
+	otherPath := filepath.Join(dir, "other.iso")
+	os.WriteFile(otherPath, []byte("GALE01"), 0644)
+
+	selectProfile("", isoPath)
+	if profile.Name != "test" {
+		t.Errorf("Expected the profile with game ID GTSTDA but got %s", profile.Name)
+	}
+	selectProfile(otherPath)
+	if profile.Name != builtinProfiles[0].Name {
+		t.Errorf("Expected the default profile for an unknown game but got %s", profile.Name)
+	}
+}
+
+// Test that a dump is normalized by writing bytes and zeroing ranges.
+func TestNormalize(t *testing.T) {
+	defer func(size int64) { GameCubeDiscSize = size }(GameCubeDiscSize)
+	GameCubeDiscSize = 0x40
+	dumpPath := filepath.Join(t.TempDir(), "dump.iso")
+	os.WriteFile(dumpPath, bytes.Repeat([]byte{0xAA}, 0x40), 0644)
+
+	isoBytes := normalize(testProfile().Normalize[0], dumpPath)
+	expected := bytes.Repeat([]byte{0xAA}, 0x40)
+	copy(expected[0x10:], []byte{0x01, 0x02})
+	copy(expected[0x20:0x30], make([]byte, 0x10))
+	if !bytes.Equal(isoBytes, expected) {
+		t.Errorf("Unexpected normalized dump %x", isoBytes)
+	}
+}
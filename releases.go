@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHubTokenEnv environment variable with a GitHub token to use instead of the one in the config
+var GitHubTokenEnv = "GITHUB_TOKEN"
+
+// maxReleasePages the most pages of releases to follow, as a safeguard against endless pagination
+var maxReleasePages = 50
+
+// linkNextPattern matches the next page of a Link header
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+type Asset struct {
+	Name        string   `json:"name"`
+	DownloadURL string   `json:"browser_download_url"`
+	Size        int64    `json:"size"`
+	Mirrors     []string `json:"mirrors,omitempty"`
+}
+
+type Tag struct {
+	Version     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Body        string    `json:"body"`
+	Prerelease  bool      `json:"prerelease"`
+	Draft       bool      `json:"draft"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []Asset   `json:"assets"`
+	// Saves what the release needs done to the saves of older versions, from a manifest
+	Saves *SaveRequirement `json:"saves,omitempty"`
+}
+
+// RateLimitError the GitHub API refused a request because the rate limit was exceeded
+type RateLimitError struct {
+	Limit int
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	wait := time.Until(e.Reset).Round(time.Second)
+	if wait < 0 {
+		wait = 0
+	}
+	return fmt.Sprintf("GitHub rate limit of %d requests per hour exceeded, it resets at %s (in %s). "+
+		"Set a token with %s or \"config set github_token\" for a higher limit",
+		e.Limit, e.Reset.Local().Format("15:04:05"), wait, GitHubTokenEnv)
+}
+
+// apiClient a client of a JSON API of releases
+type apiClient struct {
+	client *http.Client
+	// accept the media type to ask for
+	accept string
+	// header the header to send the token in, with the scheme the token is prefixed with
+	header string
+	scheme string
+	token  string
+	// cacheDir the directory to cache responses in, responses aren't cached if it is empty
+	cacheDir string
+}
+
+// Get every page of an API, starting at the given url and following the Link header.
+// The token is only sent to the host of the first page.
+func (c *apiClient) pages(pageURL string, handle func(body []byte) error) error {
+	firstURL, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+	for page := 0; pageURL != "" && page < maxReleasePages; page++ {
+		var body []byte
+		body, pageURL, err = c.get(pageURL, firstURL.Host)
+		if err != nil {
+			return err
+		}
+		if err := handle(body); err != nil {
+			return fmt.Errorf("unexpected response: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+// Get a page of an API, returning the url of the next page if there is one. A cached page is
+// only downloaded again if it changed, and is used instead when the API can't be reached.
+func (c *apiClient) get(pageURL string, host string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", c.accept)
+	if c.token != "" && req.URL.Host == host {
+		req.Header.Set(c.header, c.scheme+c.token)
+	}
+	cached := c.readCache(pageURL)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		if cached != nil {
+			fmt.Printf("Unable to reach %s, using the releases cached on %s\n", req.URL.Host, cached.Saved.Local().Format("2006-01-02 15:04"))
+			return []byte(cached.Body), cached.Next, nil
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return []byte(cached.Body), cached.Next, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if err := rateLimitError(resp); err != nil {
+			if cached != nil {
+				fmt.Printf("%s\nUsing the releases cached on %s\n", err.Error(), cached.Saved.Local().Format("2006-01-02 15:04"))
+				return []byte(cached.Body), cached.Next, nil
+			}
+			return nil, "", err
+		}
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiError) == nil && apiError.Message != "" {
+			return nil, "", fmt.Errorf("status %s: %s", resp.Status, apiError.Message)
+		}
+		return nil, "", errors.New("status " + resp.Status)
+	}
+	next := ""
+	if match := linkNextPattern.FindStringSubmatch(resp.Header.Get("Link")); match != nil {
+		next = match[1]
+	}
+	c.writeCache(pageURL, resp, body, next)
+	return body, next, nil
+}
+
+// githubSource releases of a GitHub repository
+type githubSource struct {
+	api apiClient
+	url string
+}
+
+// Create a source of the releases of a GitHub repository, using a token from the environment
+// or the config. repo is a releases API url such as
+// https://api.github.com/repos/{user}/{repository}/releases
+func newGitHubSource(repo string) *githubSource {
+	token := os.Getenv(GitHubTokenEnv)
+	if token == "" {
+		token = config.GitHubToken
+	}
+	api := apiClient{client: httpClient(), accept: "application/vnd.github+json",
+		header: "Authorization", scheme: "Bearer ", token: token, cacheDir: releaseCacheDir()}
+	return &githubSource{api: api, url: repo}
+}
+
+// Releases get all releases of the repository, newest first.
+func (s *githubSource) Releases() ([]Tag, error) {
+	var tags []Tag
+	err := s.api.pages(withQuery(s.url, "per_page", "100"), func(body []byte) error {
+		var page []Tag
+		err := json.Unmarshal(body, &page)
+		tags = append(tags, page...)
+		return err
+	})
+	return tags, err
+}
+
+// Set a query parameter of a url if it isn't set already.
+func withQuery(rawURL string, key string, value string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsed.Query()
+	if query.Get(key) == "" {
+		query.Set(key, value)
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// Return a RateLimitError if the response was refused because the rate limit was exceeded.
+func rateLimitError(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return nil
+	}
+	limit, _ := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return &RateLimitError{Limit: limit, Reset: time.Now()}
+	}
+	return &RateLimitError{Limit: limit, Reset: time.Unix(reset, 0)}
+}
+
+// Get the releases of a repository, newest first. Drafts are left out since they can't be
+// installed by anyone without access to the repository.
+func fetchTags(repo string) []Tag {
+	var published []Tag
+	for _, tag := range fetchAllTags(repo) {
+		if !tag.Draft {
+			published = append(published, tag)
+		}
+	}
+	return published
+}
+
+// Get the releases of a repository including drafts, newest first.
+func fetchAllTags(repo string) []Tag {
+	source, err := newReleaseSource(repo)
+	if err != nil {
+		fail(ExitUsage, "Unable to use %s as a release source: %s", repo, err.Error())
+	}
+	tags, err := source.Releases()
+	if err != nil {
+		fail(ExitDownloadFailed, "Unable to access releases for %s\n%s", repo, err.Error())
+	}
+	addMirrors(tags, config.Mirrors)
+	return tags
+}
+
+// Describe the flags of a release for listing it, e.g. "prerelease, 2023-08-19".
+func describeTag(tag Tag) string {
+	var flags []string
+	if tag.Draft {
+		flags = append(flags, "draft")
+	}
+	if tag.Prerelease {
+		flags = append(flags, "prerelease")
+	}
+	if !tag.PublishedAt.IsZero() {
+		flags = append(flags, tag.PublishedAt.Local().Format("2006-01-02"))
+	}
+	return strings.Join(flags, ", ")
+}
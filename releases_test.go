@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that every page of releases is followed with the Link header and that the token is
+// only sent to the host of the repository.
+func TestReleasesFollowsPages(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			t.Error("Token was sent to another host")
+		}
+		fmt.Fprint(w, `[{"tag_name": "v1", "assets": []}]`)
+	}))
+	defer other.Close()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected token but got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Query().Get("per_page") != "100" {
+			t.Errorf("Expected per_page=100 but got %q", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/releases?per_page=100&page=2>; rel="next", <%s/releases?per_page=100&page=3>; rel="last"`, server.URL, server.URL))
+			fmt.Fprint(w, `[{"tag_name": "v3", "draft": true}, {"tag_name": "v2", "prerelease": true}]`)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/releases?page=3>; rel="next"`, other.URL))
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+
+	source := &githubSource{api: apiClient{client: http.DefaultClient, header: "Authorization", scheme: "Bearer ", token: "secret"}, url: server.URL + "/releases"}
+	tags, err := source.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, tag := range tags {
+		versions = append(versions, tag.Version)
+	}
+	if strings.Join(versions, ",") != "v3,v2,v1" {
+		t.Errorf("Expected v3,v2,v1 but got %s", strings.Join(versions, ","))
+	}
+	if !tags[0].Draft || !tags[1].Prerelease {
+		t.Error("Expected the draft and prerelease flags to be read")
+	}
+}
+
+// Test that an exceeded rate limit is reported with the time it resets.
+func TestReleasesRateLimit(t *testing.T) {
+	reset := time.Now().Add(10 * time.Minute).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message": "API rate limit exceeded"}`)
+	}))
+	defer server.Close()
+
+	source := &githubSource{api: apiClient{client: http.DefaultClient}, url: server.URL}
+	_, err := source.Releases()
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("Expected a RateLimitError but got %v", err)
+	}
+	if rateLimitErr.Limit != 60 || rateLimitErr.Reset.Unix() != reset {
+		t.Errorf("Expected limit 60 resetting at %d but got %d at %d", reset, rateLimitErr.Limit, rateLimitErr.Reset.Unix())
+	}
+}
+
+// Test that other errors include the message of the API instead of failing to decode it.
+func TestReleasesNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not Found"}`)
+	}))
+	defer server.Close()
+
+	source := &githubSource{api: apiClient{client: http.DefaultClient}, url: server.URL}
+	_, err := source.Releases()
+	if err == nil || !strings.Contains(err.Error(), "Not Found") {
+		t.Errorf("Expected a Not Found error but got %v", err)
+	}
+}
+
+// Test that releases are only downloaded again when they changed, and that the cached releases
+// are used when the API can't be reached.
+func TestReleasesCache(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `[{"tag_name": "v1.0.0"}]`)
+	}))
+	repo := server.URL + "/api/v1/repos/super-gnt4/scon4/releases"
+
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			server.Close()
+		}
+		source, err := newReleaseSource(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags, err := source.Releases()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != 1 || tags[0].Version != "v1.0.0" {
+			t.Fatalf("Unexpected releases %+v", tags)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests but got %d", requests)
+	}
+	cached, _ := filepath.Glob(filepath.Join(releaseCacheDir(), "*"))
+	if len(cached) != 1 || filepath.Ext(cached[0]) != ".json" {
+		t.Errorf("Expected only the cached page but got %v", cached)
+	}
+}
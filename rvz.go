@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// RVZChunkSize the size of the chunks of written RVZs, the size Dolphin writes by default
+var RVZChunkSize int64 = 0x20000
+
+// rvzHeadersSize the size of the two headers an RVZ starts with
+const rvzHeadersSize = 0x48 + 0xDC
+
+// rvzGroupEntrySize the size of the entry of each chunk: its offset, size and packed size
+const rvzGroupEntrySize = 0x0C
+
+// rvzCompressed the flag of the size of a chunk that is compressed rather than stored as is
+const rvzCompressed = 1 << 31
+
+// rvzLZMA2 the compression type of LZMA2 in the header of an RVZ
+const rvzLZMA2 = 4
+
+// rvzWriter writes a disc image as an RVZ, Dolphin's format that compresses each chunk on its
+// own, here with LZMA2. Chunks that are all zeroes are left out and chunks that don't get smaller
+// are stored as is. A chunk for each CPU is compressed at once, since LZMA2 is slow. The entries of
+// the chunks and the headers are written last, since they are only known then.
+type rvzWriter struct {
+	blockWriter
+	unreadableImage
+	output     io.WriteSeeker
+	discHeader []byte
+	groups     []byte
+	offset     int64
+	pending    []rvzChunk
+	compressed bytes.Buffer
+}
+
+// rvzChunk a chunk waiting to be compressed along with the chunks of the other CPUs
+type rvzChunk struct {
+	data       []byte
+	compressed bytes.Buffer
+	err        error
+}
+
+// Start writing a disc image of a size as an RVZ with chunks of a size.
+func newRVZWriter(output io.WriteSeeker, size int64, chunkSize int64) (*rvzWriter, error) {
+	w := &rvzWriter{output: output, discHeader: make([]byte, 0x80), offset: rvzHeadersSize}
+	w.pending = make([]rvzChunk, 0, runtime.NumCPU())
+	w.blockWriter = blockWriter{size: size, block: make([]byte, chunkSize), writeBlock: w.writeBlock}
+	if _, err := output.Seek(rvzHeadersSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rvzWriter) writeBlock(index int, block []byte) error {
+	if index == 0 {
+		copy(w.discHeader, block)
+	}
+	n := len(w.pending)
+	w.pending = w.pending[:n+1]
+	chunk := &w.pending[n]
+	chunk.data = append(chunk.data[:0], block...)
+	if len(w.pending) == cap(w.pending) {
+		return w.writePending()
+	}
+	return nil
+}
+
+// Compress the pending chunks at once and write them in order.
+func (w *rvzWriter) writePending() error {
+	var wg sync.WaitGroup
+	for i := range w.pending {
+		chunk := &w.pending[i]
+		if isZeroes(chunk.data) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chunk.err = rvzCompress(&chunk.compressed, chunk.data, len(w.block))
+		}()
+	}
+	wg.Wait()
+	for i := range w.pending {
+		chunk := &w.pending[i]
+		entry := make([]byte, rvzGroupEntrySize)
+		binary.BigEndian.PutUint32(entry[0x00:], uint32(w.offset/4))
+		w.groups = append(w.groups, entry...)
+		if isZeroes(chunk.data) {
+			continue
+		}
+		if chunk.err != nil {
+			return chunk.err
+		}
+		data := chunk.compressed.Bytes()
+		dataSize := uint32(len(data)) | rvzCompressed
+		if len(data) >= len(chunk.data) {
+			data = chunk.data
+			dataSize = uint32(len(data))
+		}
+		binary.BigEndian.PutUint32(w.groups[len(w.groups)-rvzGroupEntrySize+4:], dataSize)
+		if err := w.writeAligned(data); err != nil {
+			return err
+		}
+	}
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// Write data and pad it to a multiple of 4 bytes, since offsets are stored divided by 4.
+func (w *rvzWriter) writeAligned(data []byte) error {
+	padding := (4 - len(data)%4) % 4
+	if _, err := w.output.Write(data); err != nil {
+		return err
+	}
+	if _, err := w.output.Write(make([]byte, padding)); err != nil {
+		return err
+	}
+	w.offset += int64(len(data) + padding)
+	return nil
+}
+
+func (w *rvzWriter) finish() error {
+	if err := w.finishBlocks(); err != nil {
+		return err
+	}
+	if err := w.writePending(); err != nil {
+		return err
+	}
+	chunkSize := len(w.block)
+	count := len(w.groups) / rvzGroupEntrySize
+
+	// A single raw data entry covers the whole disc past the disc header, as for every GameCube disc
+	rawData := make([]byte, 0x18)
+	binary.BigEndian.PutUint64(rawData[0x00:], 0x80)
+	binary.BigEndian.PutUint64(rawData[0x08:], uint64(w.size-0x80))
+	binary.BigEndian.PutUint32(rawData[0x14:], uint32(count))
+	rawDataOffset := w.offset
+	if err := rvzCompress(&w.compressed, rawData, chunkSize); err != nil {
+		return err
+	}
+	rawDataSize := w.compressed.Len()
+	if err := w.writeAligned(w.compressed.Bytes()); err != nil {
+		return err
+	}
+	groupsOffset := w.offset
+	if err := rvzCompress(&w.compressed, w.groups, chunkSize); err != nil {
+		return err
+	}
+	groupsSize := w.compressed.Len()
+	if err := w.writeAligned(w.compressed.Bytes()); err != nil {
+		return err
+	}
+
+	header2 := make([]byte, 0xDC)
+	binary.BigEndian.PutUint32(header2[0x00:], 1) // GameCube disc
+	binary.BigEndian.PutUint32(header2[0x04:], rvzLZMA2)
+	binary.BigEndian.PutUint32(header2[0x0C:], uint32(chunkSize))
+	copy(header2[0x10:], w.discHeader)
+	binary.BigEndian.PutUint32(header2[0x94:], 0x30) // partition entry size
+	binary.BigEndian.PutUint64(header2[0x98:], uint64(rawDataOffset))
+	emptyHash := sha1.Sum(nil)
+	copy(header2[0xA0:], emptyHash[:])
+	binary.BigEndian.PutUint32(header2[0xB4:], 1)
+	binary.BigEndian.PutUint64(header2[0xB8:], uint64(rawDataOffset))
+	binary.BigEndian.PutUint32(header2[0xC0:], uint32(rawDataSize))
+	binary.BigEndian.PutUint32(header2[0xC4:], uint32(count))
+	binary.BigEndian.PutUint64(header2[0xC8:], uint64(groupsOffset))
+	binary.BigEndian.PutUint32(header2[0xD0:], uint32(groupsSize))
+	header2[0xD4] = 1
+	header2[0xD5] = rvzDictProperty(chunkSize)
+
+	header1 := make([]byte, 0x48)
+	copy(header1, "RVZ\x01")
+	binary.BigEndian.PutUint32(header1[0x04:], 0x01000000) // version
+	binary.BigEndian.PutUint32(header1[0x08:], 0x00030000) // compatible version
+	binary.BigEndian.PutUint32(header1[0x0C:], uint32(len(header2)))
+	header2Hash := sha1.Sum(header2)
+	copy(header1[0x10:], header2Hash[:])
+	binary.BigEndian.PutUint64(header1[0x24:], uint64(w.size))
+	binary.BigEndian.PutUint64(header1[0x2C:], uint64(w.offset))
+	header1Hash := sha1.Sum(header1[:0x34])
+	copy(header1[0x34:], header1Hash[:])
+
+	if _, err := w.output.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.output.Write(append(header1, header2...))
+	return err
+}
+
+// Compress data as a raw LZMA2 stream with a dictionary the size of a chunk.
+func rvzCompress(buf *bytes.Buffer, data []byte, chunkSize int) error {
+	buf.Reset()
+	writer, err := lzma.Writer2Config{DictCap: chunkSize}.NewWriter2(buf)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	// Close doesn't return the error of flushing the last chunk
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// Get the LZMA2 property byte of the smallest dictionary size that holds a chunk, which Dolphin
+// reads from the header to decompress the chunks with.
+func rvzDictProperty(chunkSize int) byte {
+	for p := 0; p < 40; p++ {
+		if (2|p&1)<<(p/2+11) >= chunkSize {
+			return byte(p)
+		}
+	}
+	return 40
+}
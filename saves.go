@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SaveActions what a release can require to be done to the saves of older versions: delete them
+// since they crash the new version, or patch them to the new save layout
+var SaveActions = []string{"reset", "migrate"}
+
+// saveActionResults how the saves are described after applying an action
+var saveActionResults = map[string]string{"reset": "deleted", "migrate": "migrated"}
+
+// SavesAsset the release asset declaring a SaveRequirement, for sources without a manifest
+var SavesAsset = "saves.json"
+
+// BackupFile the file describing a backup of saves in its directory
+var BackupFile = "backup.json"
+
+// SaveBackupsKept the number of backups made when switching versions or restoring that are kept,
+// the oldest ones beyond it are deleted
+var SaveBackupsKept = 10
+
+// SaveRequirement what a release needs done to the saves of older versions
+type SaveRequirement struct {
+	Action string `json:"action"`
+	// Patch the asset with the xdelta patch of the save data, for migrate
+	Patch string `json:"patch,omitempty"`
+}
+
+// pendingSave a save requirement of a release that is applied once the update succeeded
+type pendingSave struct {
+	version     string
+	requirement SaveRequirement
+	// patchPath the downloaded patch of a migration
+	patchPath string
+}
+
+// pendingSaves the save requirements of the releases between the active version and the one
+// being installed, oldest first
+var pendingSaves []pendingSave
+
+// SaveBackup a backup of the saves of a game, taken before switching away from a version
+type SaveBackup struct {
+	Version string       `json:"version"`
+	Created time.Time    `json:"created"`
+	Files   []BackupItem `json:"files"`
+	// Automatic whether the backup was made when switching versions or restoring, rather than
+	// with saves backup
+	Automatic bool `json:"automatic,omitempty"`
+	// dir the directory of the backup
+	dir string
+}
+
+// BackupItem a file of a backup and where it was backed up from
+type BackupItem struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Get the directory backups of saves are kept in.
+func saveBackupsDir() string {
+	return filepath.Join(DATA, "saves")
+}
+
+// Get the Dolphin region folder of a game ID, e.g. JAP for G4NJDA.
+func saveRegion(gameID string) string {
+	if len(gameID) < 4 {
+		return "EUR"
+	}
+	switch gameID[3] {
+	case 'J':
+		return "JAP"
+	case 'E':
+		return "USA"
+	}
+	return "EUR"
+}
+
+// Find the files holding saves of a game in the Dolphin user directory: the raw memory cards
+// with a save of it, which hold the saves of other games too, and its files in the GCI folders.
+func findSaveFiles(userDir string, gameID string) []string {
+	var files []string
+	region := saveRegion(gameID)
+	ini, _ := readIni(userDolphinIni(userDir))
+	for _, slot := range []string{"A", "B"} {
+		cardPath := filepath.Join(userDir, "GC", "MemoryCard"+slot+"."+region+".raw")
+		if ini != nil {
+			if configured, ok := ini.get("Core", "Memcard"+slot+"Path"); ok && configured != "" {
+				cardPath = configured
+			}
+		}
+		if data, err := ioutil.ReadFile(cardPath); err == nil {
+			if card, err := parseMemoryCard(data); err == nil && len(card.saves(gameID[:4])) > 0 {
+				files = append(files, cardPath)
+			}
+		}
+		files = append(files, findGCIFiles(filepath.Join(userDir, "GC", region, "Card "+slot), gameID)...)
+	}
+	return files
+}
+
+// Find the GCI files of a game in a GCI folder by the game code they start with.
+func findGCIFiles(dir string, gameID string) []string {
+	var files []string
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".gci") {
+			continue
+		}
+		gciPath := filepath.Join(dir, entry.Name())
+		f, err := os.Open(gciPath)
+		if err != nil {
+			continue
+		}
+		code := make([]byte, 4)
+		_, err = f.ReadAt(code, 0)
+		f.Close()
+		if err == nil && string(code) == gameID[:4] {
+			files = append(files, gciPath)
+		}
+	}
+	return files
+}
+
+// Copy the save files of a game into a new backup for a version. Returns nil if there is nothing
+// to back up.
+func backupSaves(userDir string, gameID string, version string, automatic bool) (*SaveBackup, error) {
+	files := findSaveFiles(userDir, gameID)
+	if len(files) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	name := now.Format("20060102-150405")
+	if version != "" {
+		name = fatFileName(version) + "_" + name
+	}
+	backup := &SaveBackup{Version: version, Created: now, Automatic: automatic, dir: filepath.Join(saveBackupsDir(), name)}
+	for i := 2; exists(backup.dir); i++ {
+		backup.dir = filepath.Join(saveBackupsDir(), fmt.Sprintf("%s-%d", name, i))
+	}
+	if err := os.MkdirAll(backup.dir, 0755); err != nil {
+		return nil, err
+	}
+	for i, file := range files {
+		item := BackupItem{Name: fmt.Sprintf("%d-%s", i, filepath.Base(file)), Path: file}
+		if err := copyFile(file, filepath.Join(backup.dir, item.Name)); err != nil {
+			return nil, err
+		}
+		backup.Files = append(backup.Files, item)
+	}
+	data, err := json.MarshalIndent(backup, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return backup, ioutil.WriteFile(filepath.Join(backup.dir, BackupFile), data, 0644)
+}
+
+// Get the backups of saves, oldest first.
+func saveBackups() []SaveBackup {
+	var backups []SaveBackup
+	entries, _ := os.ReadDir(saveBackupsDir())
+	for _, entry := range entries {
+		dir := filepath.Join(saveBackupsDir(), entry.Name())
+		data, err := ioutil.ReadFile(filepath.Join(dir, BackupFile))
+		if err != nil {
+			continue
+		}
+		var backup SaveBackup
+		if json.Unmarshal(data, &backup) == nil {
+			backup.dir = dir
+			backups = append(backups, backup)
+		}
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Created.Before(backups[j].Created)
+	})
+	return backups
+}
+
+// Delete the oldest automatic backups of saves beyond the newest keep. Backups made with saves
+// backup are kept until they are deleted by hand.
+func pruneSaveBackups(keep int) {
+	var automatic []SaveBackup
+	for _, backup := range saveBackups() {
+		if backup.Automatic {
+			automatic = append(automatic, backup)
+		}
+	}
+	for i := 0; i < len(automatic)-keep; i++ {
+		if err := os.RemoveAll(automatic[i].dir); err != nil {
+			fmt.Printf("Unable to delete the old backup %s: %s\n", filepath.Base(automatic[i].dir), err.Error())
+		}
+	}
+}
+
+// Restore a backup: the GCI files of the game are replaced by the ones in the backup, and its
+// saves on the memory cards by the ones on the backed up cards. The saves of other games on the
+// memory cards are left as they are now.
+func restoreSaves(userDir string, gameID string, backup SaveBackup) error {
+	for _, slot := range []string{"A", "B"} {
+		for _, gciPath := range findGCIFiles(filepath.Join(userDir, "GC", saveRegion(gameID), "Card "+slot), gameID) {
+			if err := os.Remove(gciPath); err != nil {
+				return err
+			}
+		}
+	}
+	for _, item := range backup.Files {
+		if err := os.MkdirAll(filepath.Dir(item.Path), 0755); err != nil {
+			return err
+		}
+		source := filepath.Join(backup.dir, item.Name)
+		var err error
+		if strings.EqualFold(filepath.Ext(item.Path), ".gci") || !exists(item.Path) {
+			err = copyFile(source, item.Path)
+		} else {
+			err = restoreToMemoryCard(source, item.Path, gameID)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", item.Path, err.Error())
+		}
+	}
+	return nil
+}
+
+// Replace the saves of a game on a raw memory card by its saves on a backed up copy of the card.
+func restoreToMemoryCard(backupPath string, cardPath string, gameID string) error {
+	data, err := ioutil.ReadFile(backupPath)
+	if err != nil {
+		return err
+	}
+	backupCard, err := parseMemoryCard(data)
+	if err != nil {
+		return fmt.Errorf("backup: %s", err.Error())
+	}
+	data, err = ioutil.ReadFile(cardPath)
+	if err != nil {
+		return err
+	}
+	card, err := parseMemoryCard(data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range card.saves(gameID[:4]) {
+		if err := card.delete(entry); err != nil {
+			return err
+		}
+	}
+	for _, entry := range backupCard.saves(gameID[:4]) {
+		save, err := backupCard.read(entry)
+		if err != nil {
+			return fmt.Errorf("backup: %s", err.Error())
+		}
+		if err := card.add(backupCard.data[backupCard.dir+entry*cardEntrySize:backupCard.dir+(entry+1)*cardEntrySize], save); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(cardPath, card.data, 0644)
+}
+
+// Apply a save requirement to the save files of a game.
+func applySaveRequirement(files []string, gameID string, requirement SaveRequirement, patchPath string) error {
+	for _, file := range files {
+		var err error
+		if strings.EqualFold(filepath.Ext(file), ".gci") {
+			err = applyToGCI(file, requirement, patchPath)
+		} else {
+			err = applyToMemoryCard(file, gameID, requirement, patchPath)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err.Error())
+		}
+	}
+	return nil
+}
+
+// Delete or migrate the save in a GCI file.
+func applyToGCI(gciPath string, requirement SaveRequirement, patchPath string) error {
+	if requirement.Action == "reset" {
+		return os.Remove(gciPath)
+	}
+	data, err := ioutil.ReadFile(gciPath)
+	if err != nil {
+		return err
+	}
+	if len(data) < GCIHeaderSize {
+		return fmt.Errorf("not a GCI file")
+	}
+	patched, err := patchSaveData(data[GCIHeaderSize:], patchPath)
+	if err != nil {
+		return err
+	}
+	if len(patched)%cardBlockSize != 0 {
+		return fmt.Errorf("migrated save is %d bytes, which isn't a whole number of blocks", len(patched))
+	}
+	header := append([]byte{}, data[:GCIHeaderSize]...)
+	binary.BigEndian.PutUint16(header[cardEntryBlocks:], uint16(len(patched)/cardBlockSize))
+	return ioutil.WriteFile(gciPath, append(header, patched...), 0644)
+}
+
+// Delete or migrate the saves of a game on a raw memory card.
+func applyToMemoryCard(cardPath string, gameID string, requirement SaveRequirement, patchPath string) error {
+	data, err := ioutil.ReadFile(cardPath)
+	if err != nil {
+		return err
+	}
+	card, err := parseMemoryCard(data)
+	if err != nil {
+		return err
+	}
+	for _, entry := range card.saves(gameID[:4]) {
+		if requirement.Action == "reset" {
+			err = card.delete(entry)
+		} else {
+			var save []byte
+			save, err = card.read(entry)
+			if err == nil {
+				save, err = patchSaveData(save, patchPath)
+			}
+			if err == nil {
+				err = card.write(entry, save)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(cardPath, card.data, 0644)
+}
+
+// Patch save data with an xdelta patch.
+func patchSaveData(data []byte, patchPath string) (patched []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to migrate the save: %v", r)
+		}
+	}()
+	outputPath := filepath.Join(runDir(), "save.migrated")
+	defer os.Remove(outputPath)
+	patchWithXdelta(bytes.NewReader(data), outputPath, patchPath, true)
+	return ioutil.ReadFile(outputPath)
+}
+
+// Copy a file, replacing the destination.
+func copyFile(source string, destination string) error {
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(destination, data, 0644)
+}
+
+// Get the save requirement of a release from the release or its saves.json asset.
+func saveRequirement(tag Tag, sums checksums) (SaveRequirement, bool) {
+	if tag.Saves != nil {
+		return *tag.Saves, true
+	}
+	asset, found := findAsset(tag, SavesAsset)
+	if !found {
+		return SaveRequirement{}, false
+	}
+	savesPath := filepath.Join(runDir(), SavesAsset)
+	defer os.Remove(savesPath)
+	downloadAsset(asset, savesPath, sums)
+	var requirement SaveRequirement
+	if err := json.Unmarshal([]byte(readFile(savesPath)), &requirement); err != nil {
+		fail(ExitFailure, "Unable to read %s of %s: %s", SavesAsset, tag.Version, err.Error())
+	}
+	return requirement, true
+}
+
+// Download what is needed to apply the save requirements of the releases newer than the active
+// version up to the one being installed, so that they can be applied once the update succeeded.
+func prepareSaves(tags []Tag, target Tag) {
+	pendingSaves = nil
+	if config.CurrentVersion == "" || compareVersions(target.Version, config.CurrentVersion) <= 0 {
+		return
+	}
+	var between []Tag
+	for _, tag := range tags {
+		if compareVersions(tag.Version, config.CurrentVersion) > 0 && compareVersions(tag.Version, target.Version) <= 0 {
+			between = append(between, tag)
+		}
+	}
+	sort.SliceStable(between, func(i, j int) bool {
+		return compareVersions(between[i].Version, between[j].Version) < 0
+	})
+	for _, tag := range between {
+		if !hasSaveRequirement(tag) {
+			continue
+		}
+		sums := fetchChecksums(tag, publicKeyFor(config.Repository))
+		requirement, found := saveRequirement(tag, sums)
+		if !found {
+			continue
+		}
+		pending := pendingSave{version: tag.Version, requirement: requirement}
+		switch requirement.Action {
+		case "reset":
+		case "migrate":
+			pending.patchPath = filepath.Join(runDir(), fmt.Sprintf("save-%d.xdelta", len(pendingSaves)))
+			asset, found := findAsset(tag, requirement.Patch)
+			if !found {
+				fail(ExitFailure, "Release %s migrates saves with %s, but it has no such asset", tag.Version, requirement.Patch)
+			}
+			downloadAsset(asset, pending.patchPath, sums)
+		default:
+			fail(ExitFailure, "Release %s has an unknown save action %s, expected one of %s", tag.Version, requirement.Action, strings.Join(SaveActions, ", "))
+		}
+		pendingSaves = append(pendingSaves, pending)
+	}
+}
+
+// Return whether a release declares a save requirement, without downloading anything.
+func hasSaveRequirement(tag Tag) bool {
+	_, found := findAsset(tag, SavesAsset)
+	return tag.Saves != nil || found
+}
+
+// Back up the saves of the game from the previous version after switching to a new one and apply
+// the save requirements of the new version. The new version is in place by then, so a failed
+// backup only skips the save requirements and a failed migration restores the backup.
+func switchSaves(previousVersion string, newVersion string) {
+	defer func() {
+		for _, pending := range pendingSaves {
+			if pending.patchPath != "" {
+				os.Remove(pending.patchPath)
+			}
+		}
+		pendingSaves = nil
+	}()
+	if previousVersion == newVersion {
+		return
+	}
+	userDir, found := dolphinUserDir()
+	if !found {
+		return
+	}
+	gameID := profile.GameID
+	backup, err := backupSaves(userDir, gameID, previousVersion, true)
+	if err != nil {
+		fmt.Printf("Unable to back up the saves of %s: %s\n", profile.ModName, err.Error())
+		return
+	}
+	if backup == nil {
+		return
+	}
+	pruneSaveBackups(SaveBackupsKept)
+	fmt.Printf("Backed up the saves of %s to %s\n", profile.ModName, backup.dir)
+	for _, pending := range pendingSaves {
+		fmt.Printf("%s requires the saves of older versions to be %s\n", pending.version, saveActionResults[pending.requirement.Action])
+		err := applySaveRequirement(findSaveFiles(userDir, gameID), gameID, pending.requirement, pending.patchPath)
+		if err != nil {
+			fmt.Printf("Unable to %s the saves: %s\nRestoring the backup\n", pending.requirement.Action, err.Error())
+			if err := restoreSaves(userDir, gameID, *backup); err != nil {
+				fmt.Printf("Unable to restore the backup: %s\n", err.Error())
+			}
+			return
+		}
+	}
+	if len(pendingSaves) == 0 {
+		return
+	}
+	fmt.Printf("To go back to the saves from before, run: %s saves restore %s\n", ExecutableName, filepath.Base(backup.dir))
+	if !argNonInteractive {
+		fmt.Print("Restore the saves from before now instead? [y/N]: ")
+		var input string
+		fmt.Scanln(&input)
+		if strings.EqualFold(input, "y") {
+			restoreBackup(userDir, gameID, *backup)
+		}
+	}
+}
+
+// Restore a backup after backing up the current saves, so that restoring can be undone as well.
+func restoreBackup(userDir string, gameID string, backup SaveBackup) {
+	current, err := backupSaves(userDir, gameID, config.CurrentVersion, true)
+	if err != nil {
+		fail(ExitFailure, "Unable to back up the current saves: %s", err.Error())
+	}
+	if err := restoreSaves(userDir, gameID, backup); err != nil {
+		fail(ExitFailure, "Unable to restore %s: %s", filepath.Base(backup.dir), err.Error())
+	}
+	fmt.Printf("Restored the saves of %s from %s\n", profile.ModName, filepath.Base(backup.dir))
+	if current != nil {
+		fmt.Printf("The saves from before were backed up to %s\n", filepath.Base(current.dir))
+		pruneSaveBackups(SaveBackupsKept)
+	}
+}
+
+// Print a hint to restore the newest backup of a version being switched back to.
+func suggestBackup(version string) {
+	backups := saveBackups()
+	for i := len(backups) - 1; i >= 0; i-- {
+		if backups[i].Version == version {
+			fmt.Printf("To restore the saves backed up from %s on %s, run: %s saves restore %s\n",
+				version, backups[i].Created.Local().Format("2006-01-02 15:04"), ExecutableName, filepath.Base(backups[i].dir))
+			return
+		}
+	}
+}
+
+// Run the saves subcommand to list, back up and restore the saves of the game in Dolphin.
+func savesCommand(args []string) {
+	defer recoverFailure()
+	flags := newFlagSet("saves", commands["saves"].usage)
+	argNonInteractive = true
+	flags.Parse(args)
+	args = flags.Args()
+	selectProfile()
+	if len(args) > 0 && args[0] != "list" {
+		lockDataDir()
+	}
+	loadDataDir()
+	userDir, found := dolphinUserDir()
+	if !found {
+		fail(ExitFailure, "Unable to find the Dolphin user directory, set it with: config set dolphin_dir <directory>")
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		for _, file := range findSaveFiles(userDir, profile.GameID) {
+			fmt.Println(file)
+		}
+		backups := saveBackups()
+		if len(backups) == 0 {
+			fmt.Println("No backups")
+		}
+		for _, backup := range backups {
+			fmt.Printf("%-32s %-12s %s  %d file(s)\n", filepath.Base(backup.dir), backup.Version,
+				backup.Created.Local().Format("2006-01-02 15:04"), len(backup.Files))
+		}
+	case args[0] == "backup" && len(args) == 1:
+		backup, err := backupSaves(userDir, profile.GameID, config.CurrentVersion, false)
+		if err != nil {
+			fail(ExitFailure, "Unable to back up the saves: %s", err.Error())
+		} else if backup == nil {
+			fail(ExitFailure, "There are no saves of %s in %s", profile.ModName, userDir)
+		}
+		fmt.Printf("Backed up %d file(s) to %s\n", len(backup.Files), backup.dir)
+	case args[0] == "restore" && len(args) <= 2:
+		backups := saveBackups()
+		if len(backups) == 0 {
+			fail(ExitFailure, "There are no backups to restore")
+		}
+		backup := backups[len(backups)-1]
+		if len(args) == 2 {
+			found := false
+			for _, b := range backups {
+				if filepath.Base(b.dir) == args[1] {
+					backup, found = b, true
+				}
+			}
+			if !found {
+				fail(ExitUsage, "Backup %s not found, see: %s saves list", args[1], ExecutableName)
+			}
+		}
+		restoreBackup(userDir, profile.GameID, backup)
+	default:
+		flags.Usage()
+		exit(ExitUsage)
+	}
+	exit(ExitOK)
+}
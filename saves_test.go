@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Create a formatted memory card with a save of SCON4 in 2 blocks and a save of another game.
+func testMemoryCard() []byte {
+	data := make([]byte, 64*cardBlockSize)
+	for _, block := range []int{cardDirBlock, cardDirBlock + 1} {
+		dir := data[block*cardBlockSize : (block+1)*cardBlockSize]
+		for i := 0; i < cardEntries*cardEntrySize; i++ {
+			dir[i] = 0xFF
+		}
+	}
+	dir := data[cardDirBlock*cardBlockSize:]
+	copy(dir, "G4NJDA")
+	binary.BigEndian.PutUint16(dir[cardEntryFirst:], 5)
+	binary.BigEndian.PutUint16(dir[cardEntryBlocks:], 2)
+	copy(dir[cardEntrySize:], "GALEDA")
+	binary.BigEndian.PutUint16(dir[cardEntrySize+cardEntryFirst:], 7)
+	binary.BigEndian.PutUint16(dir[cardEntrySize+cardEntryBlocks:], 1)
+	bat := data[cardBATBlock*cardBlockSize:]
+	binary.BigEndian.PutUint16(bat[cardBATFreeBlocks:], 64-cardFirstBlock-3)
+	binary.BigEndian.PutUint16(bat[cardBATMap:], 6)
+	binary.BigEndian.PutUint16(bat[cardBATMap+2:], cardLastBlock)
+	binary.BigEndian.PutUint16(bat[cardBATMap+4:], cardLastBlock)
+	for i := range data[5*cardBlockSize : 7*cardBlockSize] {
+		data[5*cardBlockSize+i] = byte(i)
+	}
+	card := &memoryCard{data: data, dir: cardDirBlock * cardBlockSize, bat: cardBATBlock * cardBlockSize}
+	card.commit()
+	return data
+}
+
+// Test that the saves of a game are found, read, replaced and deleted on a memory card.
+func TestMemoryCard(t *testing.T) {
+	card, err := parseMemoryCard(testMemoryCard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := card.saves("G4NJ")
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 save but got %d", len(entries))
+	}
+	save, err := card.read(entries[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(save) != 2*cardBlockSize || save[0x2001] != 0x01 {
+		t.Error("Unexpected save data")
+	}
+	changed := bytes.Repeat([]byte{0xAB}, len(save))
+	if err := card.write(entries[0], changed); err != nil {
+		t.Fatal(err)
+	}
+	if err := card.write(entries[0], changed[:cardBlockSize]); err == nil {
+		t.Error("Expected save data of another size to be refused")
+	}
+	if err := card.delete(entries[0]); err != nil {
+		t.Fatal(err)
+	}
+	// Parse it again to check the checksums and that both copies are valid
+	card, err = parseMemoryCard(card.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(card.saves("G4NJ")) != 0 || len(card.saves("GALE")) != 1 {
+		t.Error("Expected only the save of SCON4 to be deleted")
+	}
+	if free := binary.BigEndian.Uint16(card.data[card.bat+cardBATFreeBlocks:]); free != 64-cardFirstBlock-1 {
+		t.Errorf("Expected the blocks of the save to be freed but %d are free", free)
+	}
+	if card.data[7*cardBlockSize-1] != 0xAB {
+		t.Error("Expected the data of the written save")
+	}
+}
+
+// Test that saves are backed up, reset and restored in a fake Dolphin user directory.
+func TestBackupAndResetSaves(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	userDir := t.TempDir()
+	cardPath := filepath.Join(userDir, "GC", "MemoryCardA.JAP.raw")
+	gciDir := filepath.Join(userDir, "GC", "JAP", "Card B")
+	os.MkdirAll(gciDir, 0755)
+	os.WriteFile(cardPath, testMemoryCard(), 0644)
+	gci := append([]byte("G4NJDA"), make([]byte, GCIHeaderSize-6+cardBlockSize)...)
+	gciPath := filepath.Join(gciDir, "DA-G4NJ-SCON4.gci")
+	os.WriteFile(gciPath, gci, 0644)
+	os.WriteFile(filepath.Join(gciDir, "01-GALE-Melee.gci"), []byte("GALE01"), 0644)
+
+	files := findSaveFiles(userDir, "G4NJDA")
+	if len(files) != 2 || files[0] != cardPath || files[1] != gciPath {
+		t.Fatalf("Unexpected save files %v", files)
+	}
+	backup, err := backupSaves(userDir, "G4NJDA", "v1.0.0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applySaveRequirement(files, "G4NJDA", SaveRequirement{Action: "reset"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(findSaveFiles(userDir, "G4NJDA")) != 0 {
+		t.Error("Expected the saves to be reset")
+	}
+	if !exists(filepath.Join(gciDir, "01-GALE-Melee.gci")) {
+		t.Error("Expected the saves of other games to be kept")
+	}
+	// A save of another game made after the backup
+	data, _ := os.ReadFile(cardPath)
+	card, err := parseMemoryCard(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := card.add(append([]byte("GZLJ01"), make([]byte, cardEntrySize-6)...), bytes.Repeat([]byte{0xCD}, cardBlockSize)); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(cardPath, card.data, 0644)
+
+	backups := saveBackups()
+	if len(backups) != 1 || backups[0].Version != "v1.0.0" || backups[0].dir != backup.dir {
+		t.Fatalf("Unexpected backups %v", backups)
+	}
+	if err := restoreSaves(userDir, "G4NJDA", backups[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(findSaveFiles(userDir, "G4NJDA")) != 2 {
+		t.Error("Expected the saves to be restored")
+	}
+	data, _ = os.ReadFile(cardPath)
+	card, err = parseMemoryCard(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(card.saves("GZLJ")) != 1 || len(card.saves("GALE")) != 1 {
+		t.Error("Expected the saves of other games on the memory card to be kept")
+	}
+	original, _ := parseMemoryCard(testMemoryCard())
+	want, _ := original.read(original.saves("G4NJ")[0])
+	entries := card.saves("G4NJ")
+	if len(entries) != 1 {
+		t.Fatalf("Expected the save of SCON4 to be restored but got %d saves", len(entries))
+	}
+	if got, err := card.read(entries[0]); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Expected the save data of SCON4 to be restored, %v", err)
+	}
+}
+
+// Test that a save is added to free blocks of a memory card and refused when it doesn't fit.
+func TestMemoryCardAdd(t *testing.T) {
+	card, err := parseMemoryCard(testMemoryCard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	save := bytes.Repeat([]byte{0xCD}, 3*cardBlockSize)
+	if err := card.add(append([]byte("GZLJ01"), make([]byte, cardEntrySize-6)...), save); err != nil {
+		t.Fatal(err)
+	}
+	card, err = parseMemoryCard(card.data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := card.saves("GZLJ")
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 added save but got %d", len(entries))
+	}
+	if blocks, _ := card.blocks(entries[0]); len(blocks) != 3 || blocks[0] != 8 {
+		t.Errorf("Expected the save in the free blocks after the other saves but got %v", blocks)
+	}
+	if data, err := card.read(entries[0]); err != nil || !bytes.Equal(data, save) {
+		t.Errorf("Expected the added save data, %v", err)
+	}
+	if free := binary.BigEndian.Uint16(card.data[card.bat+cardBATFreeBlocks:]); free != 64-cardFirstBlock-6 {
+		t.Errorf("Expected the blocks of the save to be allocated but %d are free", free)
+	}
+	if err := card.add(make([]byte, cardEntrySize), make([]byte, 64*cardBlockSize)); err == nil {
+		t.Error("Expected a save larger than the free blocks to be refused")
+	}
+}
+
+// Test that only the oldest automatic backups beyond the limit are deleted.
+func TestPruneSaveBackups(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	created := time.Now().Add(-time.Hour)
+	for i, automatic := range []bool{true, false, true, true} {
+		backup := SaveBackup{Version: fmt.Sprintf("v1.0.%d", i), Created: created.Add(time.Duration(i) * time.Minute), Automatic: automatic}
+		dir := filepath.Join(saveBackupsDir(), backup.Version)
+		os.MkdirAll(dir, 0755)
+		data, _ := json.Marshal(backup)
+		os.WriteFile(filepath.Join(dir, BackupFile), data, 0644)
+	}
+	pruneSaveBackups(2)
+	var versions []string
+	for _, backup := range saveBackups() {
+		versions = append(versions, backup.Version)
+	}
+	if strings.Join(versions, " ") != "v1.0.1 v1.0.2 v1.0.3" {
+		t.Errorf("Expected the oldest automatic backup to be deleted but got %v", versions)
+	}
+}
+
+// Test that the memory cards configured in Dolphin.ini are found with XDG directories, where
+// Dolphin.ini isn't in the user directory.
+func TestFindSaveFilesXDG(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG directories are only used on Linux")
+	}
+	home := t.TempDir()
+	for _, env := range []string{"HOME", "XDG_CONFIG_HOME", "XDG_DATA_HOME", DolphinUserPathEnv} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("HOME", home)
+	os.Unsetenv("XDG_CONFIG_HOME")
+	os.Unsetenv("XDG_DATA_HOME")
+	os.Unsetenv(DolphinUserPathEnv)
+	userDir := filepath.Join(home, ".local", "share", "dolphin-emu")
+	configDir := filepath.Join(home, ".config", "dolphin-emu")
+	os.MkdirAll(userDir, 0755)
+	os.MkdirAll(configDir, 0755)
+	cardPath := filepath.Join(home, "cards", "scon4.raw")
+	os.MkdirAll(filepath.Dir(cardPath), 0755)
+	os.WriteFile(cardPath, testMemoryCard(), 0644)
+	os.WriteFile(filepath.Join(configDir, "Dolphin.ini"), []byte("[Core]\nMemcardAPath = "+cardPath+"\n"), 0644)
+
+	found, ok := dolphinUserDir()
+	if !ok || found != userDir {
+		t.Fatalf("Expected the user directory %s but got %s", userDir, found)
+	}
+	if files := findSaveFiles(userDir, "G4NJDA"); len(files) != 1 || files[0] != cardPath {
+		t.Errorf("Expected the memory card set in Dolphin.ini but got %v", files)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// UpdaterRepository the repository Six Patches of Pain itself is released from
+var UpdaterRepository = "https://api.github.com/repos/NicholasMoser/Six-Patches-Of-Pain/releases"
+
+// UpdaterPublicKey the minisign public key releases of Six Patches of Pain are signed with. It is
+// set when building a release, e.g. go build -ldflags "-X main.UpdaterPublicKey=RWQ..."
+var UpdaterPublicKey = ""
+
+// SelfUpdatedEnv is set when the updater restarts itself after updating, so that it doesn't
+// check for a newer version of itself again
+var SelfUpdatedEnv = "SIX_PATCHES_OF_PAIN_UPDATED"
+
+// argSelfUpdate boolean that specifies to update Six Patches of Pain itself before updating
+var argSelfUpdate bool
+
+// Update Six Patches of Pain itself to its newest release.
+func selfUpdateCommand(args []string) {
+	defer recoverFailure()
+	flags := newFlagSet("self-update", commands["self-update"].usage)
+	flags.Parse(args)
+	selectProfile()
+	lockDataDir()
+	loadDataDir()
+	if !selfUpdate() {
+		fail(ExitUpToDate, "Already on the latest version of Six Patches of Pain: %s", Version)
+	}
+	exit(ExitOK)
+}
+
+// Update Six Patches of Pain itself if there is a newer release and restart it with the same
+// arguments.
+func selfUpdateAndRestart() {
+	if os.Getenv(SelfUpdatedEnv) != "" {
+		return
+	}
+	if selfUpdate() {
+		executable := currentExecutable()
+		fmt.Println("Restarting Six Patches of Pain...")
+		os.Setenv(SelfUpdatedEnv, "1")
+		// The restarted updater takes the lock again
+		unlockDataDir()
+		err := restartExecutable(executable)
+		fail(ExitFailure, "Unable to restart %s: %s", executable, err.Error())
+	}
+}
+
+// Download the newest release of Six Patches of Pain for this OS and architecture, verify it
+// and replace the running executable with it. Returns false if it is already up to date.
+func selfUpdate() bool {
+	fmt.Println("Checking for a new version of Six Patches of Pain...")
+	tags := fetchTags(UpdaterRepository)
+	latest, found := selectLatest(tags, "stable")
+	if !found || compareVersions(latest.Version, Version) <= 0 {
+		return false
+	}
+	asset, found := updaterAsset(latest, runtime.GOOS, runtime.GOARCH)
+	if !found {
+		fail(ExitFailure, "Release %s of Six Patches of Pain has no build for %s %s", latest.Version, runtime.GOOS, runtime.GOARCH)
+	}
+	if UpdaterPublicKey == "" {
+		fail(ExitSignatureInvalid, "This build of Six Patches of Pain has no public key to verify updates with, download %s manually", latest.Version)
+	}
+	fmt.Printf("There is a new version of Six Patches of Pain available: %s\n", latest.Version)
+	emit(Event{Event: "self-update", Version: latest.Version})
+	sums := fetchChecksums(latest, UpdaterPublicKey)
+	zipPath := filepath.Join(runDir(), asset.Name)
+	defer os.Remove(zipPath)
+	downloadAsset(asset, zipPath, sums)
+
+	executable := currentExecutable()
+	newExecutable := executable + ".new"
+	err := extractExecutable(zipPath, newExecutable)
+	if err != nil {
+		os.Remove(newExecutable)
+		fail(ExitFailure, "Unable to extract %s: %s", asset.Name, err.Error())
+	}
+	err = replaceExecutable(executable, newExecutable)
+	if err != nil {
+		os.Remove(newExecutable)
+		fail(ExitFailure, "Unable to replace %s: %s", executable, err.Error())
+	}
+	fmt.Printf("Updated Six Patches of Pain to %s\n", latest.Version)
+	return true
+}
+
+// Find the zip of a release for an OS and architecture, e.g. Six-Patches-Of-Pain-2.1.0-Linux.zip
+// or Six-Patches-Of-Pain-2.1.0-Mac-arm64.zip. Releases without the architecture in the name are
+// amd64 builds.
+func updaterAsset(tag Tag, goos string, goarch string) (Asset, bool) {
+	osNames := map[string]string{"windows": "Windows", "darwin": "Mac", "linux": "Linux"}
+	osName, ok := osNames[goos]
+	if !ok {
+		return Asset{}, false
+	}
+	suffixes := []string{"-" + osName + "-" + goarch + ".zip"}
+	if goarch == "amd64" {
+		suffixes = append(suffixes, "-"+osName+".zip")
+	}
+	for _, suffix := range suffixes {
+		for _, asset := range tag.Assets {
+			if strings.HasPrefix(asset.Name, "Six-Patches-Of-Pain-") && strings.HasSuffix(asset.Name, suffix) {
+				return asset, true
+			}
+		}
+	}
+	return Asset{}, false
+}
+
+// Extract the executable from the zip of a release.
+func extractExecutable(zipPath string, filePath string) error {
+	zipListing, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zipListing.Close()
+	for _, f := range zipListing.File {
+		if filepath.Base(f.Name) != ExecutableName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		out, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, rc)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return fmt.Errorf("%s not found", ExecutableName)
+}
+
+// Get the path of the running executable, following symlinks so the real file is replaced.
+func currentExecutable() string {
+	executable, err := os.Executable()
+	check(err)
+	executable, err = filepath.EvalSymlinks(executable)
+	check(err)
+	return executable
+}
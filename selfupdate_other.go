@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Replace the running executable with a new one by renaming over it, which is atomic and leaves
+// the running process untouched.
+func replaceExecutable(executable string, newExecutable string) error {
+	if info, err := os.Stat(executable); err == nil {
+		os.Chmod(newExecutable, info.Mode())
+	}
+	return os.Rename(newExecutable, executable)
+}
+
+// Replace the running process with the new executable, keeping the same arguments.
+func restartExecutable(executable string) error {
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
+
+// Nothing is left behind by an update outside of Windows.
+func removeReplacedExecutable() {}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that the build for the OS and architecture is picked from the assets of a release.
+func TestUpdaterAsset(t *testing.T) {
+	tag := Tag{Version: "v2.1.0", Assets: []Asset{
+		{Name: "Six-Patches-Of-Pain-2.1.0-Windows.zip"},
+		{Name: "Six-Patches-Of-Pain-2.1.0-Mac.zip"},
+		{Name: "Six-Patches-Of-Pain-2.1.0-Mac-arm64.zip"},
+		{Name: "Six-Patches-Of-Pain-2.1.0-Linux.zip"},
+		{Name: "SHA256SUMS"},
+	}}
+	expected := map[[2]string]string{
+		{"windows", "amd64"}: "Six-Patches-Of-Pain-2.1.0-Windows.zip",
+		{"darwin", "amd64"}:  "Six-Patches-Of-Pain-2.1.0-Mac.zip",
+		{"darwin", "arm64"}:  "Six-Patches-Of-Pain-2.1.0-Mac-arm64.zip",
+		{"linux", "amd64"}:   "Six-Patches-Of-Pain-2.1.0-Linux.zip",
+	}
+	for platform, name := range expected {
+		asset, found := updaterAsset(tag, platform[0], platform[1])
+		if !found || asset.Name != name {
+			t.Errorf("Expected %s for %s but got %s", name, platform, asset.Name)
+		}
+	}
+	if _, found := updaterAsset(tag, "linux", "arm64"); found {
+		t.Error("Expected no build for linux arm64")
+	}
+}
+
+// Test that the executable is extracted from a release zip and replaces the old one.
+func TestReplaceExecutable(t *testing.T) {
+	ExecutableName = LinuxExecutableName
+	dir := t.TempDir()
+	zipPath := filepath.Join(dir, "Six-Patches-Of-Pain-2.1.0-Linux.zip")
+	zipFile, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(zipFile)
+	w, _ := writer.Create(LinuxExecutableName)
+	w.Write([]byte("new"))
+	writer.Close()
+	zipFile.Close()
+
+	executable := filepath.Join(dir, LinuxExecutableName)
+	os.WriteFile(executable, []byte("old"), 0755)
+	if err := extractExecutable(zipPath, executable+".new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceExecutable(executable, executable+".new"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(executable); string(data) != "new" {
+		t.Errorf("Expected the executable to be replaced but got %q", data)
+	}
+	if exists(executable + ".new") {
+		t.Error("Expected the new executable to be moved into place")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Replace the running executable with a new one. Windows doesn't allow a running executable to
+// be overwritten but does allow it to be renamed, so it is moved aside to a .old file which is
+// removed the next time Six Patches of Pain starts.
+func replaceExecutable(executable string, newExecutable string) error {
+	old := executable + ".old"
+	os.Remove(old)
+	if err := os.Rename(executable, old); err != nil {
+		return err
+	}
+	if err := os.Rename(newExecutable, executable); err != nil {
+		os.Rename(old, executable)
+		return err
+	}
+	return nil
+}
+
+// Run the new executable with the same arguments and exit with its exit code, since a process
+// can't be replaced on Windows.
+func restartExecutable(executable string) error {
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if eventOutput != nil {
+		cmd.Stdout = eventOutput
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	} else if err != nil {
+		return err
+	}
+	os.Exit(ExitOK)
+	return nil
+}
+
+// Remove the executable that was replaced by the last update.
+func removeReplacedExecutable() {
+	executable, err := os.Executable()
+	if err == nil {
+		os.Remove(executable + ".old")
+	}
+}
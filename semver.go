@@ -0,0 +1,185 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// semver a semantic version parsed from a release tag such as v1.2.3 or v1.3.0-beta.2
+type semver struct {
+	major      int
+	minor      int
+	patch      int
+	prerelease []string
+}
+
+// Parse a release tag as a semantic version. A v prefix, missing minor and patch numbers and
+// build metadata are accepted, e.g. "v1.2" is 1.2.0. Returns false if the tag isn't a version.
+func parseSemver(tag string) (semver, bool) {
+	version := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(tag), "v"), "V")
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		version = version[:i]
+	}
+	var prerelease []string
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		prerelease = strings.Split(version[i+1:], ".")
+		version = version[:i]
+		for _, identifier := range prerelease {
+			if identifier == "" {
+				return semver{}, false
+			}
+		}
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return semver{}, false
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		numbers[i] = n
+	}
+	return semver{major: numbers[0], minor: numbers[1], patch: numbers[2], prerelease: prerelease}, true
+}
+
+// Compare two versions by semantic version precedence, returning -1, 0 or 1.
+func (v semver) compare(other semver) int {
+	if c := compareInts(v.major, other.major); c != 0 {
+		return c
+	}
+	if c := compareInts(v.minor, other.minor); c != 0 {
+		return c
+	}
+	if c := compareInts(v.patch, other.patch); c != 0 {
+		return c
+	}
+	// A pre-release comes before the release itself
+	switch {
+	case len(v.prerelease) == 0 && len(other.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(other.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(other.prerelease); i++ {
+		a, b := v.prerelease[i], other.prerelease[i]
+		aNumber, aErr := strconv.Atoi(a)
+		bNumber, bErr := strconv.Atoi(b)
+		var c int
+		switch {
+		case aErr == nil && bErr == nil:
+			c = compareInts(aNumber, bNumber)
+		case aErr == nil:
+			c = -1
+		case bErr == nil:
+			c = 1
+		default:
+			c = strings.Compare(a, b)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.prerelease), len(other.prerelease))
+}
+
+func compareInts(a int, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Get the channel a release belongs to: nightly if it is tagged as a nightly, beta if it is
+// marked as a prerelease or has a pre-release version, otherwise stable.
+func tagChannel(tag Tag) string {
+	if strings.Contains(strings.ToLower(tag.Version), "nightly") {
+		return "nightly"
+	}
+	version, ok := parseSemver(tag.Version)
+	if tag.Prerelease || (ok && len(version.prerelease) > 0) {
+		return "beta"
+	}
+	return "stable"
+}
+
+// Return whether a release can be installed when following a channel. Each channel includes
+// the releases of the more stable channels, so beta also offers stable releases. Drafts are in
+// no channel.
+func inChannel(tag Tag, channel string) bool {
+	return !tag.Draft && channelRank(tagChannel(tag)) <= channelRank(channel)
+}
+
+func channelRank(channel string) int {
+	for i, c := range Channels {
+		if c == channel {
+			return i
+		}
+	}
+	return 0
+}
+
+// Select the newest release of a channel by semantic version. If none of the releases of the
+// channel has a version as its tag, the first one returned by the repository is used instead.
+func selectLatest(tags []Tag, channel string) (Tag, bool) {
+	var latest Tag
+	var latestVersion semver
+	found := false
+	for _, tag := range tags {
+		version, ok := parseSemver(tag.Version)
+		if !ok || !inChannel(tag, channel) {
+			continue
+		}
+		if !found || version.compare(latestVersion) > 0 {
+			latest, latestVersion, found = tag, version, true
+		}
+	}
+	if found {
+		return latest, true
+	}
+	for _, tag := range tags {
+		if inChannel(tag, channel) {
+			return tag, true
+		}
+	}
+	return Tag{}, false
+}
+
+// Compare the version of a release to the current version, returning -1, 0 or 1. Versions that
+// aren't semantic versions can only be compared for equality, they are otherwise newer.
+func compareVersions(version string, current string) int {
+	a, aOk := parseSemver(version)
+	b, bOk := parseSemver(current)
+	if aOk && bOk {
+		return a.compare(b)
+	}
+	if version == current {
+		return 0
+	}
+	return 1
+}
+
+// Find a release by its tag, accepting a tag with or without the v prefix, e.g. 1.2 for v1.2.0.
+func findVersion(tags []Tag, version string) (Tag, bool) {
+	for _, tag := range tags {
+		if tag.Version == version {
+			return tag, true
+		}
+	}
+	wanted, ok := parseSemver(version)
+	if !ok {
+		return Tag{}, false
+	}
+	for _, tag := range tags {
+		if v, ok := parseSemver(tag.Version); ok && v.compare(wanted) == 0 {
+			return tag, true
+		}
+	}
+	return Tag{}, false
+}
@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+// Test the precedence of versions, including the pre-release examples of semver.org.
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{
+		"v0.9", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "v1.0.1", "1.2", "v1.10.0", "2.0.0+build.5",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, aOk := parseSemver(ordered[i])
+		b, bOk := parseSemver(ordered[i+1])
+		if !aOk || !bOk {
+			t.Fatalf("Unable to parse %s or %s", ordered[i], ordered[i+1])
+		}
+		if a.compare(b) != -1 || b.compare(a) != 1 {
+			t.Errorf("Expected %s < %s", ordered[i], ordered[i+1])
+		}
+	}
+	for _, invalid := range []string{"latest", "1.2.3.4", "v1.x", "1.0.0-", "1.0.0-a..b"} {
+		if _, ok := parseSemver(invalid); ok {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+}
+
+// Test that the newest release is selected by version, not by the order of the repository.
+func TestSelectLatest(t *testing.T) {
+	tags := []Tag{
+		{Version: "v1.9.0"},
+		{Version: "v2.1.0-nightly.20231001"},
+		{Version: "v2.0.0-beta.1"},
+		{Version: "v2.1.0", Draft: true},
+		{Version: "v1.10.0"},
+		{Version: "v1.11.0", Prerelease: true},
+	}
+	expected := map[string]string{
+		"stable":  "v1.10.0",
+		"beta":    "v2.0.0-beta.1",
+		"nightly": "v2.1.0-nightly.20231001",
+	}
+	for channel, version := range expected {
+		latest, ok := selectLatest(tags, channel)
+		if !ok || latest.Version != version {
+			t.Errorf("Expected %s for channel %s but got %s", version, channel, latest.Version)
+		}
+	}
+	if tag, ok := findVersion(tags, "1.9"); !ok || tag.Version != "v1.9.0" {
+		t.Errorf("Expected to find v1.9.0 but got %s", tag.Version)
+	}
+	if compareVersions("v1.9.0", "v1.10.0") != -1 {
+		t.Error("Expected v1.9.0 to be older than v1.10.0")
+	}
+}
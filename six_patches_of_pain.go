@@ -6,21 +6,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
-
-	"github.com/cheggaaa/pb/v3"
 )
 
 type Iso struct {
@@ -29,17 +25,14 @@ type Iso struct {
 	isFile   bool
 }
 
+// Version the version of Six Patches of Pain
+const Version = "2.0.0"
+
 // DATA folder for data files
 var DATA = "data"
 
-// CurrentVersion current version to see if a newer version exists
-var CurrentVersion = "data/current_version"
-
-// GitRepository git repo to download new releases from
-var GitRepositoryFile = "data/git_repository"
-
-// DefaultGitRepository default git repository to download new releases from
-var GitRepository = "https://api.github.com/repos/NicholasMoser/SCON4-Releases/releases"
+// GitRepository git repository to download new releases from, defaults to the one of the profile
+var GitRepository string
 
 // argGitRepository git repository given as argument to download new releases from
 var argGitRepository string
@@ -47,24 +40,35 @@ var argGitRepository string
 // argSpecificVersion boolean that specifies if you want to select which version to download
 var argSpecificVersion bool
 
-// PatchFile the patch file to be downloaded
-var PatchFile = "data/patch.xdelta"
+// argVersion the version to install given as argument, instead of the newest one
+var argVersion string
 
-// PatchZip the patch zip to be downloaded
-var PatchZip = "data/patch.zip"
+// argChannel the release channel given as argument to follow instead of the configured one
+var argChannel string
+
+// argNonInteractive boolean that specifies to never prompt and fail fast instead
+var argNonInteractive bool
+
+// PatchFile the patch file to be downloaded. It is kept in the folder of temporary files rather
+// than the one of this run, so that an interrupted download is resumed by the next run holding
+// the lock.
+var PatchFile = filepath.Join(DATA, TempDir, "patch.xdelta")
+
+// PatchZip the patch zip to be downloaded, next to the PatchFile for the same reason
+var PatchZip = filepath.Join(DATA, TempDir, "patch.zip")
 
 // VanillaPatch the name of the vanilla xdelta patch in the PatchZip
 var VanillaPatch = "vanilla.xdelta"
 
-// GNT4ISOPath path of the GNT4 ISO if it's not in the current directory
-var GNT4ISOPath = "data/gnt4_iso_path"
-
 // GNT4ISO default name of the GNT4 iso if the user downloads it
 var GNT4ISO = "data/GNT4.iso"
 
-// argISOPath path of the GNT4 ISO given as argument
+// argISOPath path of the base game ISO given as argument
 var argISOPath string
 
+// argDraggedPath path of a file drag and dropped onto the executable
+var argDraggedPath string
+
 // WindowsExecutableName the name of the Windows executable
 var WindowsExecutableName = "Six-Patches-Of-Pain.exe"
 
@@ -74,104 +78,168 @@ var LinuxExecutableName = "Six-Patches-Of-Pain"
 // ExecutableName the name of the executable
 var ExecutableName string
 
-type Asset struct {
-	Name        string `json:"name"`
-	DownloadURL string `json:"browser_download_url"`
-}
+// Exit codes, so that scripts can tell why an update stopped
+const (
+	ExitOK               = 0
+	ExitFailure          = 1
+	ExitUsage            = 2
+	ExitNoISO            = 3
+	ExitUpToDate         = 4
+	ExitDownloadFailed   = 5
+	ExitChecksumMismatch = 6
+	ExitPatchFailed      = 7
+	ExitSignatureInvalid = 8
+	ExitNoSpace          = 9
+	ExitLocked           = 10
+)
 
-type Tag struct {
-	Version string  `json:"tag_name"`
-	Assets  []Asset `json:"assets"`
+// exitCodeNames short names of the exit codes for JSON events
+var exitCodeNames = map[int]string{
+	ExitFailure:          "failure",
+	ExitUsage:            "usage",
+	ExitNoISO:            "no-iso",
+	ExitUpToDate:         "up-to-date",
+	ExitDownloadFailed:   "download-failed",
+	ExitChecksumMismatch: "checksum-mismatch",
+	ExitPatchFailed:      "patch-failed",
+	ExitSignatureInvalid: "signature-invalid",
+	ExitNoSpace:          "no-space",
+	ExitLocked:           "locked",
 }
 
 func main() {
-	version := "2.0.0"
-	fmt.Printf("Starting Six Patches of Pain %s....\n", version)
+	ExecutableName = LinuxExecutableName
+	if runtime.GOOS == "windows" {
+		ExecutableName = WindowsExecutableName
+	}
+	removeReplacedExecutable()
+	// Update by default so that drag and drop and the original flags keep working
+	args := os.Args[1:]
+	name := "update"
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name = args[0]
+			args = args[1:]
+		}
+	}
+	commands[name].run(args)
+	unlockDataDir()
+}
+
+// Download the newest release and patch the vanilla base game ISO with it.
+func updateCommand(args []string) {
+	defer recoverFailure()
+	argParse(args)
+	if argJSON {
+		enableJSONEvents()
+	}
+	fmt.Printf("Starting Six Patches of Pain %s....\n", Version)
 	fmt.Println()
-	argParse()
+	selectProfile(argISOPath, argDraggedPath)
+	emit(Event{Event: "start", Version: Version, Profile: profile.Name})
 	verifyIntegrity()
-	gnt4Iso := getGNT4ISO()
+	if argSelfUpdate {
+		selfUpdateAndRestart()
+	}
+	baseIso := getBaseISO()
+	emit(Event{Event: "iso-detected", Path: baseIso.filePath})
 	var newVersion string
-	if argSpecificVersion {
+	if argSpecificVersion || argVersion != "" {
 		newVersion = downloadSpecificVersion()
 	} else {
 		newVersion = downloadNewVersion()
 	}
-	outputIso := fmt.Sprintf("SCON4-%s.iso", newVersion)
-	patchGNT4(gnt4Iso, outputIso)
-	setCurrentVersion(newVersion)
+	format := outputFormat()
+	outputImage := imagePath(filepath.Join(config.OutputDir, outputName(newVersion)), format)
+	checkOutputSpace(PatchFile, outputImage, format)
+	game := startNintendont(PatchFile)
+	patchBaseISO(baseIso, PatchFile, outputImage, format, game)
+	writeNintendont(game)
+	previousVersion := config.CurrentVersion
+	recordInstallation(newVersion, outputImage)
+	switchSaves(previousVersion, newVersion)
+	registerWithDolphin(outputImage)
+	installDolphinExtras(PatchZip)
 	if exists(PatchFile) {
 		os.Remove(PatchFile)
 	}
-	exit(0)
+	if exists(PatchZip) {
+		os.Remove(PatchZip)
+	}
+	outputPath, err := filepath.Abs(outputImage)
+	check(err)
+	emit(Event{Event: "completed", Version: newVersion, Path: outputPath})
+	launchDolphin(outputImage)
+	exit(ExitOK)
 }
 
-// Parse the arguments
-func argParse() {
-	flag.StringVar(&argGitRepository, "r", "", "Specify git repository to download updates from as 'https://api.github.com/repos/{user}/{repository}/releases'")
-	flag.StringVar(&argISOPath, "p", "", "Specify path of the GNT4 ISO")
-	flag.BoolVar(&argSpecificVersion, "specific", false, "Select a specific version to download")
-	flag.Parse()
+// Parse the arguments of the update command
+func argParse(args []string) {
+	flags := newFlagSet("update", "[iso]")
+	flags.StringVar(&argGitRepository, "r", "", "Specify repository to download updates from as 'https://api.github.com/repos/{user}/{repository}/releases', or a GitLab, Gitea or Forgejo releases API url, a releases.json url or a local directory")
+	flags.StringVar(&argISOPath, "p", "", "Specify path of the base game ISO")
+	flags.BoolVar(&argSpecificVersion, "specific", false, "Select a specific version to download")
+	flags.StringVar(&argVersion, "version", "", "Install the release with this tag, e.g. v1.2.0, even if it is older than the installed one")
+	flags.StringVar(&argChannel, "channel", "", "Follow the stable, beta or nightly releases instead of the configured channel")
+	flags.BoolVar(&argJSON, "json", false, "Write newline-delimited JSON events to stdout instead of text, implies -yes")
+	flags.BoolVar(&argSelfUpdate, "self-update", false, "Update Six Patches of Pain itself first if there is a newer version")
+	flags.StringVar(&argOutputFormat, "output-format", "", "Save the patched ISO as an iso, ciso, gcz or rvz")
+	flags.StringVar(&argNintendontDir, "nintendont", "", "Also write the patched ISO to the games folder of this SD card or USB drive for Nintendont")
+	flags.StringVar(&argNintendontFormat, "nintendont-format", "", "Write the game for Nintendont as an iso or a ciso")
+	flags.BoolVar(&argLaunch, "launch", false, "Start the patched ISO in Dolphin after updating")
+	flags.Var(&argRetries, "retries", fmt.Sprintf("Number of times a failed download is retried, 0 to never retry (default %d)", DefaultRetries))
+	flags.IntVar(&argTimeout, "timeout", 0, fmt.Sprintf("Seconds without any data after which a download is retried (default %d)", DefaultTimeout))
+	flags.Parse(args)
+	// A single positional argument is an ISO drag and dropped onto the executable
+	if flags.NArg() == 1 {
+		argDraggedPath = flags.Arg(0)
+	}
 }
 
 // Verify the integrity of the auto-updater and required files.
 func verifyIntegrity() {
-	// Create the data directory if it doesn't already exist
-	if !exists(DATA) {
-		err := os.Mkdir(DATA, 0755)
-		check(err)
-	}
-	if argGitRepository != "" {
-		GitRepository = argGitRepository
-	}
 	if argISOPath != "" {
 		GNT4ISO = argISOPath
 	}
-	// If git repository is not set, set it to the default release repository. If set, but different from argument, reset if saveConfig arg is set
-	if !exists(GitRepositoryFile) {
-		d1 := []byte(GitRepository)
-		err := ioutil.WriteFile(GitRepositoryFile, d1, 0644)
-		check(err)
-	}
-	if argGitRepository != "" && readFile(GitRepositoryFile) != argGitRepository {
-		d1 := []byte(GitRepository)
-		err := ioutil.WriteFile(GitRepositoryFile, d1, 0644)
-		check(err)
-	}
-	// If iso path is not set, set it to the default. If set, but different from argument, reset if saveConfig arg is set
-	if !exists(GNT4ISOPath) {
-		d1 := []byte(GNT4ISO)
-		err := ioutil.WriteFile(GNT4ISOPath, d1, 0644)
-		check(err)
-	}
-	if argISOPath != "" && readFile(GNT4ISOPath) != GNT4ISO {
-		d1 := []byte(GNT4ISO)
-		err := ioutil.WriteFile(GNT4ISOPath, d1, 0644)
+	// Lock the data directory so that another run can't change it at the same time, then load the
+	// config and save any settings given as arguments to it
+	lockDataDir()
+	loadDataDir()
+	if argGitRepository != "" && config.Repository != argGitRepository {
+		config.Repository = argGitRepository
+		saveConfig()
+	}
+	if argISOPath != "" && config.ISOPath != argISOPath {
+		config.ISOPath = argISOPath
+		saveConfig()
+	}
+	// Check the formats and the Nintendont drive before spending time on the update
+	outputFormat()
+	nintendontFormat()
+	checkNintendontDir()
+	// Create the output directory if it doesn't already exist
+	if config.OutputDir != "" && !exists(config.OutputDir) {
+		err := os.MkdirAll(config.OutputDir, 0755)
 		check(err)
 	}
-	// Delete any existing patch files, since they may be corrupted/old
-	if exists(PatchFile) {
-		os.Remove(PatchFile)
-	}
 }
 
-// Retrieves the vanilla GNT4 iso to patch against.
-func getGNT4ISO() Iso {
+// Retrieves the vanilla base game iso to patch against.
+func getBaseISO() Iso {
 	// First, check if it was drag and dropped onto the executable or provided as an arg
-	if len(os.Args) == 2 && !argSpecificVersion {
-		var draggedPath = os.Args[1]
-		if exists(draggedPath) {
-			isGNT4, isoBytes := isGNT4(draggedPath)
-			if isGNT4 {
-				setGNT4ISOPath(draggedPath)
+	if dragged := argDraggedPath; dragged != "" {
+		if exists(dragged) {
+			isBase, isoBytes := isBaseGame(dragged)
+			if isBase {
+				setGNT4ISOPath(dragged)
 				if isoBytes != nil {
-					return Iso{bytes: isoBytes, isFile: false}
+					return Iso{filePath: dragged, bytes: isoBytes, isFile: false}
 				}
-				return Iso{filePath: draggedPath, isFile: true}
+				return Iso{filePath: dragged, isFile: true}
 			}
-			fmt.Println("Provided file is not a vanilla GNT4 ISO: " + draggedPath)
+			fmt.Printf("Provided file is not a vanilla %s ISO: %s\n", profile.GameName, dragged)
 		} else {
-			fmt.Println("Provided file does not exist: " + draggedPath)
+			fmt.Println("Provided file does not exist: " + dragged)
 		}
 	}
 	// Then look for if it was provided as a named arg
@@ -180,18 +248,18 @@ func getGNT4ISO() Iso {
 		// If you're using this method, we can hopefully assume it will be a correct vanilla ISO
 		return Iso{filePath: isoPath, isFile: true}
 	}
-	// Then look for the ISO in GNT4_ISO_PATH
-	if exists(GNT4ISOPath) {
-		isoPath := readFile(GNT4ISOPath)
+	// Then look for the ISO at the path in the config
+	if config.ISOPath != "" {
+		isoPath := config.ISOPath
 		if exists(isoPath) {
-			isGNT4, isoBytes := isGNT4(isoPath)
-			if isGNT4 {
+			isBase, isoBytes := isBaseGame(isoPath)
+			if isBase {
 				if isoBytes != nil {
-					return Iso{bytes: isoBytes, isFile: false}
+					return Iso{filePath: isoPath, bytes: isoBytes, isFile: false}
 				}
 				return Iso{filePath: isoPath, isFile: true}
 			} else {
-				fmt.Println("GNT4_ISO_PATH iso is not a vanilla GNT4 ISO: " + isoPath)
+				fmt.Printf("Configured iso_path is not a vanilla %s ISO: %s\n", profile.GameName, isoPath)
 			}
 		}
 	}
@@ -203,14 +271,14 @@ func getGNT4ISO() Iso {
 			return err
 		}
 		if !info.IsDir() {
-			isGNT4, isoBytes := isGNT4(path)
-			if isGNT4 {
+			isBase, isoBytes := isBaseGame(path)
+			if isBase {
 				// Found, stop searching by returning EOF
 				if isoBytes != nil {
-					gnt4Iso = Iso{bytes: isoBytes, isFile: false}
+					gnt4Iso = Iso{filePath: path, bytes: isoBytes, isFile: false}
 					gnt4Path = path
 				} else {
-					gnt4Iso = Iso{filePath: isoPath, isFile: true}
+					gnt4Iso = Iso{filePath: path, isFile: true}
 					gnt4Path = path
 				}
 				return io.EOF
@@ -226,31 +294,35 @@ func getGNT4ISO() Iso {
 		return gnt4Iso
 	}
 	// Last resort, query the user for the location of the ISO
+	if argNonInteractive {
+		fail(ExitNoISO, "No vanilla %s ISO found, provide one with -p", profile.GameName)
+	}
 	for true {
-		fmt.Printf("This updater requires a vanilla GNT4 ISO in order to auto-update.\n")
+		gameName := profile.GameName
+		fmt.Printf("This updater requires a vanilla %s ISO in order to auto-update.\n", gameName)
 		fmt.Printf("Please do one of the following:\n")
-		fmt.Printf("  - Exit this application and drag and drop your vanilla GNT4 ISO onto %s\n", ExecutableName)
-		fmt.Printf("  - Enter the file path to your local copy of a vanilla GNT4 ISO\n")
-		fmt.Printf("  - Move a vanilla GNT4 ISO to this folder and restart %s\n", ExecutableName)
-		fmt.Printf("  - Enter a link to a download for a vanilla GNT4 ISO\n")
+		fmt.Printf("  - Exit this application and drag and drop your vanilla %s ISO onto %s\n", gameName, ExecutableName)
+		fmt.Printf("  - Enter the file path to your local copy of a vanilla %s ISO\n", gameName)
+		fmt.Printf("  - Move a vanilla %s ISO to this folder and restart %s\n", gameName, ExecutableName)
+		fmt.Printf("  - Enter a link to a download for a vanilla %s ISO\n", gameName)
 		fmt.Println()
 		fmt.Print("Input: ")
 		var input string
 		fmt.Scanln(&input)
 		if exists(input) {
 			// Local file
-			isGNT4, isoBytes := isGNT4(input)
-			if isGNT4 {
+			isBase, isoBytes := isBaseGame(input)
+			if isBase {
 				setGNT4ISOPath(input)
 				if isoBytes != nil {
-					return Iso{bytes: isoBytes, isFile: false}
+					return Iso{filePath: input, bytes: isoBytes, isFile: false}
 				}
 				return Iso{filePath: input, isFile: true}
 			}
-			fmt.Printf("\nERROR: %s is not a clean vanilla GNT4 ISO\n\n", input)
+			fmt.Printf("\nERROR: %s is not a clean vanilla %s ISO\n\n", input, profile.GameName)
 		} else {
 			// Download from interwebs
-			err := download(input, GNT4ISO)
+			err := download(input, GNT4ISO, 0)
 			if err != nil {
 				fmt.Printf("Failed to download file with error: %s\n\n", err.Error())
 				if exists(GNT4ISO) {
@@ -258,15 +330,15 @@ func getGNT4ISO() Iso {
 				}
 			} else {
 				if exists(GNT4ISO) {
-					isGNT4, isoBytes := isGNT4(GNT4ISO)
-					if isGNT4 {
+					isBase, isoBytes := isBaseGame(GNT4ISO)
+					if isBase {
 						setGNT4ISOPath(GNT4ISO)
 						if isoBytes != nil {
-							return Iso{bytes: isoBytes, isFile: false}
+							return Iso{filePath: GNT4ISO, bytes: isoBytes, isFile: false}
 						}
 						return Iso{filePath: GNT4ISO, isFile: true}
 					}
-					fmt.Printf("\nERROR: Downloaded file was not a vanilla GNT4 ISO.\n\n")
+					fmt.Printf("\nERROR: Downloaded file was not a vanilla %s ISO.\n\n", profile.GameName)
 					os.Remove(GNT4ISO)
 				}
 			}
@@ -278,59 +350,102 @@ func getGNT4ISO() Iso {
 // Download a new release if it exists and return the version name.
 func downloadNewVersion() string {
 	// Get the latest release
-	repo := readFile(GitRepositoryFile)
-	resp, err := http.Get(repo)
-	check(err)
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		fmt.Printf("Unable to access releases for %s\nStatus code: %s", repo, resp.Status)
+	repo := config.Repository
+	tags := fetchTags(repo)
+	if len(tags) == 0 {
+		fail(ExitFailure, "No releases found at %s", repo)
 	}
-	body, err := io.ReadAll(resp.Body)
-	check(err)
-	var tags []Tag
-	err2 := json.Unmarshal(body, &tags)
-	check(err2)
-	// Stop if the latest release has already been patched locally
-	latestTag := tags[0]
+	channel := releaseChannel()
+	latestTag, found := selectLatest(tags, channel)
+	if !found {
+		fail(ExitFailure, "No %s releases found at %s", channel, repo)
+	}
+	// Stop if the latest release, or a newer one, has already been patched locally. Downgrades
+	// are only made when asked for with -version
 	latestVersion := latestTag.Version
-	if exists(CurrentVersion) {
-		currentVersion := readFile(CurrentVersion)
-		if currentVersion == latestVersion {
-			fmt.Println("Already on latest SCON4 version: " + latestVersion)
-			fmt.Println("If you wish to re-download the latest version,")
-			fmt.Println("please delete the file named current_version in the data folder.")
-			fail()
+	if config.CurrentVersion != "" {
+		switch compareVersions(latestVersion, config.CurrentVersion) {
+		case 0:
+			fmt.Println("If you wish to re-download the latest version, please run:")
+			fmt.Printf("  %s config unset current_version\n", ExecutableName)
+			fail(ExitUpToDate, "Already on latest %s version: %s", profile.ModName, latestVersion)
+		case -1:
+			fmt.Println("If you wish to install it anyway, please run:")
+			fmt.Printf("  %s -version %s\n", ExecutableName, latestVersion)
+			fail(ExitUpToDate, "Installed %s version %s is newer than the latest %s release: %s", profile.ModName, config.CurrentVersion, channel, latestVersion)
 		}
 	}
+	fmt.Printf("\nThere is a new version of %s available: %s\n", profile.ModName, latestVersion)
+	printChangelog(tags, latestTag)
+	latestTag = confirmRelease(tags, latestTag)
+	latestVersion = latestTag.Version
 	// Download the patch
 	if len(latestTag.Assets) == 0 {
-		fmt.Println("No assets found in latest release for " + repo)
-		fail()
+		fail(ExitFailure, "No assets found in release %s for %s", latestVersion, repo)
 	}
+	prepareSaves(tags, latestTag)
+	sums := fetchChecksums(latestTag, publicKeyFor(config.Repository))
 	for i := 0; i < len(latestTag.Assets); i++ {
 		asset := latestTag.Assets[i]
 		name := asset.Name
 		if name == "patch.xdelta" {
-			downloadURL := latestTag.Assets[0].DownloadURL
-			fmt.Println("\nThere is a new version of SCON4 available: " + latestVersion)
 			fmt.Println("Downloading: " + latestVersion)
-			download(downloadURL, PatchFile)
+			downloadAsset(asset, PatchFile, sums)
 			return latestVersion
 		} else if name == "patches.zip" {
-			downloadURL := latestTag.Assets[0].DownloadURL
-			fmt.Println("\nThere is a new version of SCON4 available: " + latestVersion)
 			fmt.Println("Downloading: " + latestVersion)
-			download(downloadURL, PatchZip)
+			downloadAsset(asset, PatchZip, sums)
 			unzipPatch()
-			os.Remove(PatchZip)
 			return latestVersion
 		}
 	}
-	fmt.Println("Unable to find either patch.xdelta or patches.zip")
-	fail()
+	fail(ExitFailure, "Unable to find either patch.xdelta or patches.zip")
+	return ""
+}
+
+// Get the release channel to follow, preferring the argument over the config.
+func releaseChannel() string {
+	if argChannel == "" {
+		return config.Channel
+	}
+	for _, channel := range Channels {
+		if channel == argChannel {
+			return channel
+		}
+	}
+	fail(ExitUsage, "Unknown channel %s, expected one of %s", argChannel, strings.Join(Channels, ", "))
 	return ""
 }
 
+// Download a release asset from its url or one of its mirrors and verify it against the checksums
+// of the release, failing with ExitDownloadFailed if it can't be downloaded or
+// ExitChecksumMismatch if it doesn't match. A mirror whose file doesn't match is skipped for the
+// next one. Mirrors are only used when there are checksums to verify them with.
+func downloadAsset(asset Asset, filePath string, sums checksums) {
+	urls := []string{asset.DownloadURL}
+	if sums != nil {
+		urls = append(urls, asset.Mirrors...)
+	} else if len(asset.Mirrors) > 0 {
+		fmt.Printf("Not using the mirrors of %s, since there are no checksums to verify them with\n", asset.Name)
+	}
+	fetchAsset(asset, urls, filePath, ExitChecksumMismatch, func(path string) error {
+		return sums.verify(asset.Name, path)
+	})
+}
+
+// Download an asset from the first of some urls that works and whose file passes verification,
+// failing with ExitDownloadFailed if none can be downloaded or with the given exit code if the
+// last one doesn't pass.
+func fetchAsset(asset Asset, urls []string, filePath string, code int, verify func(string) error) {
+	err := downloadFromMirrors(urls, filePath, asset.Size, verify)
+	var verifyErr *verifyError
+	if errors.As(err, &verifyErr) {
+		fail(code, "Failed to verify %s: %s", asset.Name, verifyErr.Error())
+	} else if err != nil {
+		fail(ExitDownloadFailed, "Failed to download %s with error: %s", asset.DownloadURL, err.Error())
+	}
+}
+
 func unzipPatch() {
 	zipListing, err := zip.OpenReader(PatchZip)
 	check(err)
@@ -342,6 +457,7 @@ func unzipPatch() {
 		}
 
 		// Found vanilla.xdelta
+		checkVolume(PatchFile, int64(f.UncompressedSize64))
 		dstFile, err := os.OpenFile(PatchFile, os.O_RDWR|os.O_CREATE, 0644)
 		check(err)
 		rc, err := f.Open()
@@ -351,57 +467,58 @@ func unzipPatch() {
 		rc.Close()
 		return
 	}
-	fmt.Println("Unable to find vanilla.xdelta")
-	fail()
+	fail(ExitFailure, "Unable to find vanilla.xdelta")
 }
 
 // Specify which available version to download
 func downloadSpecificVersion() string {
 	// Get a specific release
-	repo := readFile(GitRepositoryFile)
-	resp, err := http.Get(repo)
-	check(err)
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		fmt.Printf("Unable to access releases for %s\nStatus code: %s", repo, resp.Status)
-	}
-	body, err := io.ReadAll(resp.Body)
-	check(err)
-	var tags []Tag
-	err2 := json.Unmarshal(body, &tags)
-	check(err2)
+	repo := config.Repository
+	tags := fetchTags(repo)
 	if len(tags) == 0 {
-		fmt.Println("No releases found at " + repo)
-		fail()
-	}
-	for i := 0; i < len(tags); i++ {
-		fmt.Println(i, ": ", tags[i].Version)
-	}
-	fmt.Print("Enter the number of the wished release: ")
-	var input int
-	fmt.Scanln(&input)
-	if input >= len(tags) {
-		input = len(tags) - 1
-	} else if input < 0 {
-		input = 0
+		fail(ExitFailure, "No releases found at %s", repo)
+	}
+	var specificRelease Tag
+	if argVersion != "" {
+		var found bool
+		specificRelease, found = findVersion(tags, argVersion)
+		if !found {
+			fail(ExitUsage, "Version %s not found at %s", argVersion, repo)
+		}
+	} else {
+		if argNonInteractive {
+			fail(ExitUsage, "Selecting a specific version requires input, which is disabled by -yes. Use -version instead")
+		}
+		for i := 0; i < len(tags); i++ {
+			fmt.Println(i, ": ", tags[i].Version)
+		}
+		fmt.Print("Enter the number of the wished release: ")
+		var input int
+		fmt.Scanln(&input)
+		if input >= len(tags) {
+			input = len(tags) - 1
+		} else if input < 0 {
+			input = 0
+		}
+		specificRelease = tags[input]
 	}
-	specificRelease := tags[input]
 	specificVersion := specificRelease.Version
+	printChangelog(tags, specificRelease)
+	prepareSaves(tags, specificRelease)
 	// Download the patch
 	assets := specificRelease.Assets
 	if len(assets) == 0 {
-		fmt.Println("No assets found in latest release for " + repo)
-		fail()
+		fail(ExitFailure, "No assets found in latest release for %s", repo)
 	}
+	sums := fetchChecksums(specificRelease, publicKeyFor(config.Repository))
 	// First for passivity with older releases, prefer uncompressed_patch.xdelta as that
 	// is that is now the only patch time supported by the native xdelta impl
 	for i := 0; i < len(specificRelease.Assets); i++ {
 		asset := specificRelease.Assets[i]
 		name := asset.Name
 		if name == "uncompressed_patch.xdelta" {
-			downloadURL := specificRelease.Assets[i].DownloadURL
 			fmt.Println("Downloading: " + specificVersion)
-			download(downloadURL, PatchFile)
+			downloadAsset(asset, PatchFile, sums)
 			return specificVersion
 		}
 	}
@@ -410,122 +527,114 @@ func downloadSpecificVersion() string {
 		asset := specificRelease.Assets[i]
 		name := asset.Name
 		if name == "patch.xdelta" {
-			downloadURL := specificRelease.Assets[i].DownloadURL
 			fmt.Println("Downloading: " + specificVersion)
-			download(downloadURL, PatchFile)
+			downloadAsset(asset, PatchFile, sums)
 			return specificVersion
 		} else if name == "patches.zip" {
-			downloadURL := specificRelease.Assets[i].DownloadURL
 			fmt.Println("Downloading: " + specificVersion)
-			download(downloadURL, PatchZip)
+			downloadAsset(asset, PatchZip, sums)
 			unzipPatch()
-			os.Remove(PatchZip)
 			return specificVersion
 		}
 	}
-	fmt.Println("Unable to find uncompressed_patch.xdelta, patch.xdelta, or patches.zip")
-	fail()
+	fail(ExitFailure, "Unable to find uncompressed_patch.xdelta, patch.xdelta, or patches.zip")
 	return ""
 }
 
-// Patches the given GNT4 ISO to the output SCON4 ISO path using the downloaded patch.
-func patchGNT4(gnt4Iso Iso, scon4Iso string) {
-	fmt.Println("\nPatching GNT4...")
+// Patches the given base game ISO using the given patch and writes it as an image in a format,
+// first to a .part file that is renamed once it is complete. The patched data is also passed on
+// to the game for Nintendont, if any.
+func patchBaseISO(baseIso Iso, patchPath string, outputImage string, format string, game *nintendontGame) {
+	fmt.Printf("\nPatching %s...\n", profile.GameName)
+
+	partPath := outputImage + ".part"
+	var file *os.File
+	defer func() {
+		if r := recover(); r != nil {
+			if file != nil {
+				file.Close()
+			}
+			os.Remove(partPath)
+			game.abort()
+			if err, ok := r.(error); ok && errors.Is(err, ErrChecksumMismatch) {
+				fail(ExitChecksumMismatch, "\nFailed to patch ISO: %v", r)
+			}
+			fail(ExitPatchFailed, "\nFailed to patch ISO: %v", r)
+		}
+	}()
+
+	size, err := xdeltaTargetSize(patchPath)
+	check(err)
+	file, err = os.Create(partPath)
+	check(err)
+	image, err := newImageWriter(file, format, size)
+	check(err)
+	// The patched data goes straight into the image, so a compressed image needs no room for an ISO
+	var output xdeltaOutput = image
+	if game != nil {
+		output = nintendontOutput{image, game}
+	}
 
-	if gnt4Iso.isFile {
+	if baseIso.isFile {
 		// Patch from file input
-		input, err := os.Open(gnt4Iso.filePath)
+		input, err := os.Open(baseIso.filePath)
 		check(err)
 		defer input.Close()
-		patchWithXdelta(input, scon4Iso, PatchFile, true)
+		patchXdeltaTo(input, output, patchPath, true)
 	} else {
 		// Patch from bytes input
-		input := bytes.NewReader(gnt4Iso.bytes)
-		patchWithXdelta(input, scon4Iso, PatchFile, true)
+		input := bytes.NewReader(baseIso.bytes)
+		patchXdeltaTo(input, output, patchPath, true)
 	}
+	check(image.finish())
+	check(file.Close())
+	check(os.Rename(partPath, outputImage))
 
-	if exists(scon4Iso) && getFileSize(scon4Iso) > 0 {
-		isoFullPath, err := filepath.Abs(scon4Iso)
-		check(err)
-		fmt.Println("\nPatching complete. Saved to " + isoFullPath)
-	} else {
-		fmt.Println("\nFailed to patch ISO, see above messages for more info.")
-		exit(1)
-	}
+	fullPath, err := filepath.Abs(outputImage)
+	check(err)
+	fmt.Println("\nPatching complete. Saved to " + fullPath)
 }
 
-// Returns whether or not the given file path is vanilla GNT4.
-func isGNT4(filePath string) (bool, []byte) {
-	if strings.HasSuffix(strings.ToLower(filePath), ".iso") || strings.HasSuffix(strings.ToLower(filePath), ".ciso") {
-		f, err := os.Open(filePath)
-		check(err)
-		data := make([]byte, 6)
-		len, err := f.Read(data)
-		check(err)
-		f.Close()
-		expected := []byte("G4NJDA")
-		cisoExpected := []byte{0x43, 0x49, 0x53, 0x4F, 0x00, 0x00} // CISO
-		if reflect.DeepEqual(expected, data[:len]) || reflect.DeepEqual(cisoExpected, data[:len]) {
-			fmt.Println("Validating GNT4 ISO is not modified...")
-			hashValue, err := hashFile(filePath)
-			check(err)
-			// 60aefa3e is the CRC32 hash of both a good ISO dump AND an Nkit ISO somehow
-			// Check whether this file is an ISO or an Nkit
-			if hashValue == "60aefa3e" {
-				if isNkit(filePath) {
-					// This is an Nkit ISO, but we currently use a "bad" ISO dump instead.
-					// The bad dump is superior as it pads with zeroes instead of random bytes.
-					// Confirm the user is okay with modifying their Nkit to be a bad dump.
-					fmt.Println("\nConverting NKIT to ISO...")
-					isoBytes := convertNkitToIso(filePath)
-					return true, isoBytes
-				} else {
-					// This is a good ISO dump, but we currently use a "bad" dump instead.
-					// The bad dump is superior as it pads with zeroes instead of random bytes.
-					// Confirm the user is okay with modifying their good dump to be a bad dump.
-					fmt.Println("\nConverting good dump ISO to bad dump ISO...")
-					isoBytes := patchGoodDump(filePath)
-					return true, isoBytes
-				}
-			} else if hashValue == "0371b18c" {
-				// 0371b18c is the CRC32 hash of a ciso file, so we must first convert it to an ISO
-				// Confirm the user is okay with modifying their ciso to be an ISO
-				fmt.Println("\nConverting CISO to ISO...")
-				isoBytes := patchCISO(filePath)
-				return true, isoBytes
-			}
-			return hashValue == "55ee8b1a", nil
-		}
+// Returns whether or not the given file path is the vanilla base game of the profile.
+// If the file is a known dump that had to be normalized, the normalized bytes are returned.
+func isBaseGame(filePath string) (bool, []byte) {
+	_, isBase, rule := identifyBaseGame(filePath)
+	if !isBase || rule == nil {
+		return isBase, nil
 	}
-	return false, nil
+	fmt.Printf("\nConverting %s to the expected %s ISO...\n", rule.Name, profile.GameName)
+	return true, normalize(*rule, filePath)
 }
 
-// Patches a good dump of vanilla GNT4 to be the expected "bad" dump of GNT4
-func patchGoodDump(filePath string) []byte {
-
-	// Read original bytes
-	isoBytes, err := os.ReadFile(filePath)
+// Identify whether the given file path is the vanilla base game of the profile by its CRC32 hash.
+// If it's a known dump that has to be normalized first, the rule to normalize it is returned.
+func identifyBaseGame(filePath string) (string, bool, *NormalizeRule) {
+	lowerPath := strings.ToLower(filePath)
+	if !strings.HasSuffix(lowerPath, ".iso") && !strings.HasSuffix(lowerPath, ".ciso") {
+		return "", false, nil
+	}
+	if readGameID(filePath) != profile.GameID {
+		return "", false, nil
+	}
+	fmt.Printf("Validating %s ISO is not modified...\n", profile.GameName)
+	hashValue, err := hashFile(filePath)
 	check(err)
-
-	// First write this weird four byte word to bi2.bin
-	copy(isoBytes[0x500:], []byte{0x00, 0x52, 0x02, 0x02})
-
-	var zeroes [4096]byte
-	// There are random padding bytes from 0x248104 to 0xC4F8000 (0xC2AFEFC bytes).
-	// Replace them with zeroes by looping 49839 times. Then add 3836 extra zeroes.
-	for i := 0; i < 49839; i++ {
-		offset := 0x248104 + (i * 4096)
-		copy(isoBytes[int64(offset):], zeroes[:])
+	for _, hash := range profile.Hashes {
+		if hashValue == hash {
+			return hashValue, true, nil
+		}
 	}
-	var moreZeroes [3836]byte
-	copy(isoBytes[0xC4F7104:], moreZeroes[:])
-
-	var evenMoreZeroes [11108]byte
-	// There are random padding bytes from 0x4553001C - 0x45532B7F (0x2B63 bytes).
-	// Just add 11108 zeroes directly.
-	copy(isoBytes[0x4553001C:], evenMoreZeroes[:])
-
-	return isoBytes
+	// Check whether this is a known dump that can be converted to the expected one
+	for i, rule := range profile.Normalize {
+		if rule.CRC32 != hashValue {
+			continue
+		}
+		if rule.Converter != "" && !converters[rule.Converter].matches(filePath) {
+			continue
+		}
+		return hashValue, true, &profile.Normalize[i]
+	}
+	return hashValue, false, nil
 }
 
 // Patches a CISO of vanilla GNT4 to be the expected "bad" dump of GNT4
@@ -554,10 +663,7 @@ func patchCISO(filePath string) []byte {
 	buf := make([]byte, buf_size)
 	i := int64(0x500000)
 	offset := int64(0xBFF8000)
-	iterations := 0x4AB5D800 / buf_size
-	bar := pb.StartNew(iterations)
-	bar.Set(pb.Bytes, true)
-	bar.Set(pb.SIBytesPrefix, true)
+	bar := startProgress("convert-progress", 0x4AB5D800)
 	for {
 		num, err := in.ReadAt(buf, i)
 		check(err)
@@ -573,7 +679,7 @@ func patchCISO(filePath string) []byte {
 			copy(isoBytes[i+offset:], buf)
 		}
 		i += int64(buf_size)
-		bar.Increment()
+		bar.Add(int64(buf_size))
 	}
 	bar.Finish()
 
@@ -640,10 +746,7 @@ func convertNkitToIso(input string) []byte {
 	buf := make([]byte, buf_size)
 	i := int64(0x250000)
 	offset := int64(0xC2A8000)
-	iterations := 0x4AB5D800 / buf_size
-	bar := pb.StartNew(iterations)
-	bar.Set(pb.Bytes, true)
-	bar.Set(pb.SIBytesPrefix, true)
+	bar := startProgress("convert-progress", 0x4AB5D800)
 	for {
 		num, err1 := in.ReadAt(buf, i)
 		// Need to write out bytes before EOF check since you can have both EOF and bytes read
@@ -662,7 +765,7 @@ func convertNkitToIso(input string) []byte {
 			offset += 0x2B7C
 		}
 		i += int64(buf_size)
-		bar.Increment()
+		bar.Add(int64(buf_size))
 	}
 	bar.Finish()
 
@@ -672,42 +775,16 @@ func convertNkitToIso(input string) []byte {
 	return isoBytes
 }
 
-// Download to a file path the file at the given url.
-func download(url string, filePath string) error {
-	resp, err := http.Get(url)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return errors.New("Unable to download file, status: " + resp.Status)
-	}
-	bar := pb.Full.Start64(resp.ContentLength)
-	bar.Set(pb.Bytes, true)
-	bar.Set(pb.SIBytesPrefix, true)
-	defer bar.Finish()
-	barReader := bar.NewProxyReader(resp.Body)
-	out, err := os.Create(filePath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-	_, err = io.Copy(out, barReader)
-	return err
-}
-
-//  Set the vanilla GNT4 ISO path to the vanilla GNT4 ISO path file.
+// Set the vanilla GNT4 ISO path in the config.
 func setGNT4ISOPath(filePath string) {
-	data := []byte(filePath)
-	err := ioutil.WriteFile(GNT4ISOPath, data, 0644)
-	check(err)
+	config.ISOPath = filePath
+	saveConfig()
 }
 
-// Set the new version to the current version file.
+// Set the active version in the config.
 func setCurrentVersion(version string) {
-	data := []byte(version)
-	err := ioutil.WriteFile(CurrentVersion, data, 0644)
-	check(err)
+	config.CurrentVersion = version
+	saveConfig()
 }
 
 // Retrieves the CRC32 hash of a given file.
@@ -719,9 +796,7 @@ func hashFile(filePath string) (string, error) {
 		return returnCRC32String, err
 	}
 	defer file.Close()
-	bar := pb.Full.Start64(fileSize)
-	bar.Set(pb.Bytes, true)
-	bar.Set(pb.SIBytesPrefix, true)
+	bar := startProgress("hash-progress", fileSize)
 	defer bar.Finish()
 	barReader := bar.NewProxyReader(file)
 	tablePolynomial := crc32.MakeTable(crc32.IEEE)
@@ -744,12 +819,22 @@ func isCommandAvailable(name string) bool {
 	return true
 }
 
-// Delete the patch file if it exists and exit with exit code 1.
-func fail() {
+// Print the error message, delete the patch file if it exists and exit with the given exit code.
+func fail(code int, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	fmt.Println(message)
+	emit(Event{Event: "error", Code: code, Error: exitCodeNames[code], Message: strings.TrimSpace(message)})
 	if exists(PatchFile) {
 		os.Remove(PatchFile)
 	}
-	exit(1)
+	exit(code)
+}
+
+// Recover from an unexpected error so that it fails with ExitFailure instead of crashing.
+func recoverFailure() {
+	if r := recover(); r != nil {
+		fail(ExitFailure, "\nUnexpected error: %v", r)
+	}
 }
 
 // Read a file to a string
@@ -759,6 +844,29 @@ func readFile(filePath string) string {
 	return string(content)
 }
 
+// Write a file by writing a temporary file next to it and renaming that into place, so that a
+// concurrent reader sees either the old or the new contents but never a partly written file.
+func writeFileAtomic(filePath string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
 // Get the file size for a file (assumes the file exists)
 func getFileSize(filePath string) int64 {
 	fi, err := os.Stat(filePath)
@@ -784,11 +892,15 @@ func contains(s []int64, val int64) bool {
 	return false
 }
 
-// Query user to exit and exit with given code.
+// Query user to exit and exit with given code. Exits immediately when non-interactive.
 func exit(code int) {
-	fmt.Println("\nPress enter to exit...")
-	var output string
-	fmt.Scanln(&output)
+	// Unlock before pausing, so that a window left open doesn't keep the data directory locked
+	unlockDataDir()
+	if !argNonInteractive {
+		fmt.Println("\nPress enter to exit...")
+		var output string
+		fmt.Scanln(&output)
+	}
 	os.Exit(code)
 }
 
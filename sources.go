@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LocalManifest the name of the manifest of a local directory of releases
+var LocalManifest = "releases.json"
+
+// GitLabTokenEnv environment variable with a GitLab token for private projects
+var GitLabTokenEnv = "GITLAB_TOKEN"
+
+// GiteaTokenEnv environment variable with a Gitea or Forgejo token for private repositories
+var GiteaTokenEnv = "GITEA_TOKEN"
+
+// ReleaseSource a place where the releases of a mod are published
+type ReleaseSource interface {
+	// Releases get all releases, newest first
+	Releases() ([]Tag, error)
+}
+
+// Create the release source for a configured url. The type of source is picked from the url:
+//   - https://api.github.com/repos/{owner}/{repository}/releases or https://github.com/{owner}/{repository}
+//   - https://{host}/api/v4/projects/{id}/releases or https://gitlab.com/{group}/{project} for GitLab
+//   - https://{host}/api/v1/repos/{owner}/{repository}/releases for Gitea and Forgejo
+//   - https://{host}/{path}.json for a static manifest of releases
+//   - a local directory or manifest, as a path or a file:// url
+func newReleaseSource(repo string) (ReleaseSource, error) {
+	if exists(repo) {
+		return newLocalSource(repo)
+	}
+	parsed, err := url.Parse(repo)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "file" {
+		return newLocalSource(localPath(parsed))
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, errors.New("expected an http, https or file url, or an existing directory")
+	}
+	path := strings.Trim(parsed.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case strings.HasSuffix(path, ".json"):
+		return &manifestSource{client: httpClient(), url: repo}, nil
+	case parsed.Host == "api.github.com":
+		return newGitHubSource(repo), nil
+	case parsed.Host == "github.com" && len(parts) == 2:
+		return newGitHubSource("https://api.github.com/repos/" + path + "/releases"), nil
+	case strings.Contains(parsed.Path, "/api/v4/projects/"):
+		return newGitLabSource(repo), nil
+	case parsed.Host == "gitlab.com" && len(parts) >= 2:
+		return newGitLabSource("https://gitlab.com/api/v4/projects/" + url.PathEscape(path) + "/releases"), nil
+	case strings.Contains(parsed.Path, "/api/v1/repos/"):
+		return newGiteaSource(repo), nil
+	}
+	return nil, errors.New("unknown type of release source, expected the releases API url of GitHub, GitLab, Gitea or Forgejo, or a .json manifest")
+}
+
+// giteaSource releases of a Gitea or Forgejo repository, whose API has the same shape as GitHub's
+type giteaSource struct {
+	api apiClient
+	url string
+}
+
+func newGiteaSource(repo string) *giteaSource {
+	api := apiClient{client: httpClient(), accept: "application/json",
+		header: "Authorization", scheme: "token ", token: os.Getenv(GiteaTokenEnv), cacheDir: releaseCacheDir()}
+	return &giteaSource{api: api, url: repo}
+}
+
+// Releases get all releases of the repository, newest first.
+func (s *giteaSource) Releases() ([]Tag, error) {
+	var tags []Tag
+	err := s.api.pages(withQuery(s.url, "limit", "50"), func(body []byte) error {
+		var page []Tag
+		err := json.Unmarshal(body, &page)
+		tags = append(tags, page...)
+		return err
+	})
+	return tags, err
+}
+
+// gitlabSource releases of a GitLab project
+type gitlabSource struct {
+	api apiClient
+	url string
+}
+
+// gitlabRelease a release of the GitLab API, whose assets are links
+type gitlabRelease struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	ReleasedAt  time.Time `json:"released_at"`
+	Assets      struct {
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+func newGitLabSource(repo string) *gitlabSource {
+	api := apiClient{client: httpClient(), accept: "application/json",
+		header: "PRIVATE-TOKEN", token: os.Getenv(GitLabTokenEnv), cacheDir: releaseCacheDir()}
+	return &gitlabSource{api: api, url: repo}
+}
+
+// Releases get all releases of the project, newest first. GitLab doesn't report the size of
+// asset links, so their size is unknown.
+func (s *gitlabSource) Releases() ([]Tag, error) {
+	var tags []Tag
+	err := s.api.pages(withQuery(s.url, "per_page", "100"), func(body []byte) error {
+		var page []gitlabRelease
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		for _, release := range page {
+			tag := Tag{Version: release.TagName, Name: release.Name, Body: release.Description, PublishedAt: release.ReleasedAt}
+			for _, link := range release.Assets.Links {
+				downloadURL := link.DirectAssetURL
+				if downloadURL == "" {
+					downloadURL = link.URL
+				}
+				tag.Assets = append(tag.Assets, Asset{Name: link.Name, DownloadURL: downloadURL})
+			}
+			tags = append(tags, tag)
+		}
+		return nil
+	})
+	return tags, err
+}
+
+// manifestSource releases listed in a static JSON file, for mods hosted on a plain web server
+type manifestSource struct {
+	client *http.Client
+	url    string
+}
+
+// manifestRelease a release of a static manifest. Asset urls may be relative to the manifest.
+type manifestRelease struct {
+	Version     string    `json:"version"`
+	Name        string    `json:"name"`
+	Notes       string    `json:"notes"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []struct {
+		Name    string   `json:"name"`
+		URL     string   `json:"url"`
+		Size    int64    `json:"size"`
+		Mirrors []string `json:"mirrors"`
+	} `json:"assets"`
+	Saves *SaveRequirement `json:"saves"`
+}
+
+// Releases get the releases of the manifest, in the order they are listed.
+func (s *manifestSource) Releases() ([]Tag, error) {
+	base, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	api := apiClient{client: s.client, accept: "application/json", cacheDir: releaseCacheDir()}
+	var tags []Tag
+	err = api.pages(s.url, func(body []byte) error {
+		page, err := parseManifest(body, base)
+		tags = append(tags, page...)
+		return err
+	})
+	return tags, err
+}
+
+// Parse a manifest of releases, resolving the urls of its assets relative to the manifest url.
+func parseManifest(body []byte, base *url.URL) ([]Tag, error) {
+	var releases []manifestRelease
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	for _, release := range releases {
+		tag := Tag{Version: release.Version, Name: release.Name, Body: release.Notes,
+			Prerelease: release.Prerelease, PublishedAt: release.PublishedAt, Saves: release.Saves}
+		for _, asset := range release.Assets {
+			assetURL, err := base.Parse(asset.URL)
+			if err != nil {
+				return nil, err
+			}
+			var mirrors []string
+			for _, mirror := range asset.Mirrors {
+				mirrorURL, err := base.Parse(mirror)
+				if err != nil {
+					return nil, err
+				}
+				mirrors = append(mirrors, mirrorURL.String())
+			}
+			tag.Assets = append(tag.Assets, Asset{Name: asset.Name, DownloadURL: assetURL.String(), Size: asset.Size, Mirrors: mirrors})
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// localSource releases on a local drive or network share, for updating without internet.
+// Either the directory has a manifest, or each subdirectory is a release named after its
+// version with the assets in it, e.g. <dir>/v1.2.0/patches.zip
+type localSource struct {
+	dir      string
+	manifest string
+}
+
+// Create a source of releases in a local directory, or of a local manifest.
+func newLocalSource(path string) (*localSource, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		if !strings.HasSuffix(path, ".json") {
+			return nil, errors.New("expected a directory or a .json manifest")
+		}
+		return &localSource{dir: filepath.Dir(path), manifest: path}, nil
+	}
+	source := &localSource{dir: path}
+	if manifest := filepath.Join(path, LocalManifest); exists(manifest) {
+		source.manifest = manifest
+	}
+	return source, nil
+}
+
+// Releases get the releases of the manifest in the order they are listed, or else the releases
+// of the subdirectories, newest version first.
+func (s *localSource) Releases() ([]Tag, error) {
+	if s.manifest != "" {
+		body, err := os.ReadFile(s.manifest)
+		if err != nil {
+			return nil, err
+		}
+		base, err := fileURL(s.manifest)
+		if err != nil {
+			return nil, err
+		}
+		return parseManifest(body, base)
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		tag, err := s.release(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, aOk := parseSemver(tags[i].Version)
+		b, bOk := parseSemver(tags[j].Version)
+		if aOk && bOk {
+			return a.compare(b) > 0
+		} else if aOk != bOk {
+			return aOk
+		}
+		return tags[i].PublishedAt.After(tags[j].PublishedAt)
+	})
+	return tags, nil
+}
+
+// Get the release in a subdirectory, with the files in it as assets.
+func (s *localSource) release(version string) (Tag, error) {
+	dir := filepath.Join(s.dir, version)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return Tag{}, err
+	}
+	tag := Tag{Version: version, Name: version, PublishedAt: info.ModTime()}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Tag{}, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return Tag{}, err
+		}
+		assetURL, err := fileURL(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return Tag{}, err
+		}
+		tag.Assets = append(tag.Assets, Asset{Name: entry.Name(), DownloadURL: assetURL.String(), Size: info.Size()})
+	}
+	return tag, nil
+}
+
+// Get the file:// url of a local path.
+func fileURL(path string) (*url.URL, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	slashed := filepath.ToSlash(abs)
+	if strings.HasPrefix(slashed, "//") {
+		// A network share such as \\server\share\releases
+		parts := strings.SplitN(slashed[2:], "/", 2)
+		if len(parts) == 2 {
+			return &url.URL{Scheme: "file", Host: parts[0], Path: "/" + parts[1]}, nil
+		}
+	}
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed
+	}
+	return &url.URL{Scheme: "file", Path: slashed}, nil
+}
+
+// Get the local path of a file:// url given as a string.
+func localFile(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "file" {
+		return "", errors.New("expected a file url: " + rawURL)
+	}
+	return localPath(parsed), nil
+}
+
+// Get the local path of a file:// url, e.g. file:///C:/releases is C:\releases on Windows.
+func localPath(fileURL *url.URL) string {
+	path := fileURL.Path
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	if fileURL.Host != "" && fileURL.Host != "localhost" {
+		path = "//" + fileURL.Host + path
+	}
+	return filepath.FromSlash(path)
+}
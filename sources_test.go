@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that the type of release source is picked from the url.
+func TestNewReleaseSource(t *testing.T) {
+	expected := map[string]string{
+		"https://api.github.com/repos/NicholasMoser/SCON4-Releases/releases": "*main.githubSource",
+		"https://github.com/NicholasMoser/SCON4-Releases":                    "*main.githubSource",
+		"https://gitlab.com/api/v4/projects/1234/releases":                   "*main.gitlabSource",
+		"https://gitlab.com/super-gnt4/scon4":                                "*main.gitlabSource",
+		"https://git.example.com/api/v1/repos/super-gnt4/scon4/releases":     "*main.giteaSource",
+		"https://example.com/scon4/releases.json":                            "*main.manifestSource",
+	}
+	for repo, sourceType := range expected {
+		source, err := newReleaseSource(repo)
+		if err != nil {
+			t.Errorf("Unable to create source for %s: %s", repo, err.Error())
+		} else if fmt.Sprintf("%T", source) != sourceType {
+			t.Errorf("Expected %s for %s but got %T", sourceType, repo, source)
+		}
+	}
+	source, _ := newReleaseSource("https://gitlab.com/super-gnt4/scon4")
+	if url := source.(*gitlabSource).url; url != "https://gitlab.com/api/v4/projects/super-gnt4%2Fscon4/releases" {
+		t.Errorf("Unexpected GitLab API url %s", url)
+	}
+	for _, repo := range []string{"https://example.com/releases", "ftp://example.com/releases.json"} {
+		if _, err := newReleaseSource(repo); err == nil {
+			t.Errorf("Expected %s to be rejected", repo)
+		}
+	}
+}
+
+// Test that GitLab releases are converted with their asset links and the token header.
+func TestGitLabReleases(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			t.Errorf("Expected token but got %q", r.Header.Get("PRIVATE-TOKEN"))
+		}
+		fmt.Fprint(w, `[{"tag_name": "v1.1.0", "description": "Notes", "released_at": "2023-08-19T12:00:00Z",
+			"assets": {"links": [{"name": "patches.zip", "url": "https://example.com/a", "direct_asset_url": "https://example.com/b"}]}}]`)
+	}))
+	defer server.Close()
+
+	source := newGitLabSource(server.URL + "/api/v4/projects/1/releases")
+	source.api.token = "secret"
+	tags, err := source.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Version != "v1.1.0" || tags[0].Body != "Notes" || tags[0].PublishedAt.Year() != 2023 {
+		t.Fatalf("Unexpected releases %+v", tags)
+	}
+	if len(tags[0].Assets) != 1 || tags[0].Assets[0].DownloadURL != "https://example.com/b" {
+		t.Errorf("Unexpected assets %+v", tags[0].Assets)
+	}
+}
+
+// Test that the asset urls of a static manifest are resolved relative to the manifest.
+func TestManifestReleases(t *testing.T) {
+	setDataDir(t.TempDir())
+	defer setDataDir("data")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[{"version": "v1.2.0-beta.1", "prerelease": true,
+			"assets": [{"name": "patches.zip", "url": "v1.2.0-beta.1/patches.zip", "size": 10},
+				{"name": "SHA256SUMS", "url": "https://cdn.example.com/SHA256SUMS"}]}]`)
+	}))
+	defer server.Close()
+
+	source, err := newReleaseSource(server.URL + "/scon4/releases.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := source.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || !tags[0].Prerelease || len(tags[0].Assets) != 2 {
+		t.Fatalf("Unexpected releases %+v", tags)
+	}
+	if url := tags[0].Assets[0].DownloadURL; url != server.URL+"/scon4/v1.2.0-beta.1/patches.zip" {
+		t.Errorf("Unexpected asset url %s", url)
+	}
+	if url := tags[0].Assets[1].DownloadURL; url != "https://cdn.example.com/SHA256SUMS" {
+		t.Errorf("Unexpected asset url %s", url)
+	}
+}
+
+// Test that releases are read from the subdirectories of a local directory, newest first, and
+// that their assets can be downloaded from the file:// urls.
+func TestLocalReleases(t *testing.T) {
+	dir := t.TempDir()
+	for _, version := range []string{"v1.9.0", "v1.10.0", "v1.2.0"} {
+		os.Mkdir(filepath.Join(dir, version), 0755)
+		os.WriteFile(filepath.Join(dir, version, "patch.xdelta"), []byte(version), 0644)
+	}
+	source, err := newReleaseSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := source.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 3 || tags[0].Version != "v1.10.0" || tags[1].Version != "v1.9.0" || tags[2].Version != "v1.2.0" {
+		t.Fatalf("Unexpected releases %+v", tags)
+	}
+	asset := tags[0].Assets[0]
+	if asset.Name != "patch.xdelta" || asset.Size != int64(len("v1.10.0")) {
+		t.Fatalf("Unexpected asset %+v", asset)
+	}
+	output := filepath.Join(t.TempDir(), "patch.xdelta")
+	if err := download(asset.DownloadURL, output, asset.Size); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "v1.10.0" {
+		t.Errorf("Unexpected download %q", data)
+	}
+
+	// A manifest in the directory is used instead of the subdirectories
+	manifest := `[{"version": "v2.0.0", "assets": [{"name": "patch.xdelta", "url": "v1.2.0/patch.xdelta"}]}]`
+	os.WriteFile(filepath.Join(dir, LocalManifest), []byte(manifest), 0644)
+	dirURL, _ := fileURL(dir)
+	source, err = newReleaseSource(dirURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err = source.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0].Version != "v2.0.0" {
+		t.Fatalf("Unexpected releases %+v", tags)
+	}
+	path, err := localFile(tags[0].Assets[0].DownloadURL)
+	if err != nil || path != filepath.Join(dir, "v1.2.0", "patch.xdelta") {
+		t.Errorf("Unexpected asset path %s", path)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Installation a patched ISO written by an update
+type Installation struct {
+	Version     string    `json:"version"`
+	Path        string    `json:"path"`
+	Source      string    `json:"source,omitempty"`
+	CRC32       string    `json:"crc32,omitempty"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// UnmarshalJSON also accepts the plain version strings of config version 1, which only recorded
+// the version of each installation.
+func (i *Installation) UnmarshalJSON(data []byte) error {
+	var version string
+	if err := json.Unmarshal(data, &version); err == nil {
+		*i = Installation{Version: version}
+		return nil
+	}
+	// Decode as a type without this method to not recurse
+	type installation Installation
+	return json.Unmarshal(data, (*installation)(i))
+}
+
+// Fill in the paths of installations migrated from config version 1, if their ISO is where an
+// update would have written it.
+func migrateInstallations() {
+	for i := range config.InstalledVersions {
+		installation := &config.InstalledVersions[i]
+		if installation.Path != "" {
+			continue
+		}
+		isoPath, err := filepath.Abs(filepath.Join(config.OutputDir, outputName(installation.Version)))
+		if err == nil && exists(isoPath) {
+			installation.Path = isoPath
+			if info, err := os.Stat(isoPath); err == nil {
+				installation.InstalledAt = info.ModTime()
+			}
+		}
+	}
+}
+
+// Record the ISO patched by an update and make its version the active one.
+func recordInstallation(version string, isoPath string) {
+	absPath, err := filepath.Abs(isoPath)
+	check(err)
+	hash, err := hashFile(absPath)
+	check(err)
+	installation := Installation{
+		Version:     version,
+		Path:        absPath,
+		Source:      config.Repository,
+		CRC32:       hash,
+		InstalledAt: time.Now(),
+	}
+	if i := findInstallation(version); i >= 0 {
+		config.InstalledVersions[i] = installation
+	} else {
+		config.InstalledVersions = append(config.InstalledVersions, installation)
+	}
+	setCurrentVersion(version)
+}
+
+// Get the index of the installation of a version, or -1 if it isn't installed.
+func findInstallation(version string) int {
+	for i, installation := range config.InstalledVersions {
+		if installation.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get the versions of every installation.
+func installedVersions() []string {
+	var versions []string
+	for _, installation := range config.InstalledVersions {
+		versions = append(versions, installation.Version)
+	}
+	return versions
+}
+
+// Find the installation to roll back to from the active version: the newest installed version
+// older than it, or else the one installed before it. Installations whose ISO is gone are skipped.
+func previousInstallation() (Installation, bool) {
+	var previous Installation
+	found := false
+	for _, installation := range config.InstalledVersions {
+		if installation.Version == config.CurrentVersion || !exists(installation.Path) {
+			continue
+		}
+		if compareVersions(installation.Version, config.CurrentVersion) >= 0 {
+			continue
+		}
+		if !found || compareVersions(installation.Version, previous.Version) > 0 {
+			previous, found = installation, true
+		}
+	}
+	if found {
+		return previous, true
+	}
+	active := findInstallation(config.CurrentVersion)
+	for i := active - 1; i >= 0; i-- {
+		if exists(config.InstalledVersions[i].Path) {
+			return config.InstalledVersions[i], true
+		}
+	}
+	return Installation{}, false
+}
+
+// Delete the ISO of an installation and remove it from the registry.
+func deleteInstallation(version string) error {
+	i := findInstallation(version)
+	if i < 0 {
+		return fmt.Errorf("%s is not installed", version)
+	}
+	installation := config.InstalledVersions[i]
+	if installation.Path != "" && exists(installation.Path) {
+		if err := os.Remove(installation.Path); err != nil {
+			return err
+		}
+	}
+	config.InstalledVersions = append(config.InstalledVersions[:i], config.InstalledVersions[i+1:]...)
+	if config.CurrentVersion == version {
+		config.CurrentVersion = ""
+	}
+	unregisterFromDolphin(installation.Path)
+	return nil
+}
+
+// Run the versions subcommand to manage the installed versions, e.g. "versions rollback".
+func versionsCommand(args []string) {
+	defer recoverFailure()
+	flags := newFlagSet("versions", commands["versions"].usage)
+	argNonInteractive = true
+	keep := flags.Int("keep", 1, "Number of versions besides the active one that prune keeps")
+	flags.Parse(args)
+	if *keep < 0 {
+		fail(ExitUsage, "-keep must be 0 or more but is %d", *keep)
+	}
+	args = flags.Args()
+	selectProfile()
+	if len(args) > 0 && args[0] != "list" {
+		lockDataDir()
+	}
+	loadDataDir()
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		listInstallations()
+	case args[0] == "use" && len(args) == 2:
+		useInstallation(args[1])
+	case args[0] == "rollback" && len(args) == 1:
+		previous, found := previousInstallation()
+		if !found {
+			fail(ExitFailure, "There is no installed version to roll back to from %s", config.CurrentVersion)
+		}
+		useInstallation(previous.Version)
+	case args[0] == "delete" && len(args) >= 2:
+		for _, version := range args[1:] {
+			if version == config.CurrentVersion {
+				fail(ExitUsage, "%s is the active version, switch to another version before deleting it", version)
+			}
+			if err := deleteInstallation(version); err != nil {
+				saveConfig()
+				fail(ExitFailure, "Unable to delete %s: %s", version, err.Error())
+			}
+			fmt.Printf("Deleted %s\n", version)
+		}
+		saveConfig()
+	case args[0] == "prune" && len(args) == 1:
+		pruneInstallations(*keep)
+	default:
+		flags.Usage()
+		exit(ExitUsage)
+	}
+}
+
+// Print the installed versions with the path and size of their ISO.
+func listInstallations() {
+	if len(config.InstalledVersions) == 0 {
+		fmt.Println("No versions installed")
+		return
+	}
+	for _, installation := range config.InstalledVersions {
+		marker := " "
+		if installation.Version == config.CurrentVersion {
+			marker = "*"
+		}
+		size := "missing"
+		if installation.Path != "" && exists(installation.Path) {
+			size = formatBytes(getFileSize(installation.Path))
+		}
+		installedAt := "unknown date"
+		if !installation.InstalledAt.IsZero() {
+			installedAt = installation.InstalledAt.Local().Format("2006-01-02")
+		}
+		fmt.Printf("%s %-12s %-12s %10s  %s\n", marker, installation.Version, installedAt, size, installation.Path)
+	}
+}
+
+// Make an installed version the active one.
+func useInstallation(version string) {
+	i := findInstallation(version)
+	if i < 0 {
+		fail(ExitUsage, "%s is not installed, installed versions are: %v", version, installedVersions())
+	}
+	installation := config.InstalledVersions[i]
+	if !exists(installation.Path) {
+		fail(ExitFailure, "The ISO of %s no longer exists: %s", version, installation.Path)
+	}
+	previousVersion := config.CurrentVersion
+	setCurrentVersion(version)
+	if config.Dolphin == "default" {
+		registerWithDolphin(installation.Path)
+	}
+	fmt.Printf("Now using %s: %s\n", version, installation.Path)
+	switchSaves(previousVersion, version)
+	suggestBackup(version)
+}
+
+// Delete the ISOs of all but the active version and the given number of most recently installed
+// other versions. A version that was reinstalled keeps its place in the installed versions, so
+// they are ordered by when they were installed. Versions from before that was recorded count as
+// the oldest.
+func pruneInstallations(keep int) {
+	var others []Installation
+	for _, installation := range config.InstalledVersions {
+		if installation.Version != config.CurrentVersion {
+			others = append(others, installation)
+		}
+	}
+	sort.SliceStable(others, func(i, j int) bool {
+		return others[i].InstalledAt.Before(others[j].InstalledAt)
+	})
+	var reclaimed int64
+	for i := 0; i < len(others)-keep; i++ {
+		if exists(others[i].Path) {
+			reclaimed += getFileSize(others[i].Path)
+		}
+		if err := deleteInstallation(others[i].Version); err != nil {
+			saveConfig()
+			fail(ExitFailure, "Unable to delete %s: %s", others[i].Version, err.Error())
+		}
+		fmt.Printf("Deleted %s\n", others[i].Version)
+	}
+	saveConfig()
+	fmt.Printf("Reclaimed %s, %d version(s) left\n", formatBytes(reclaimed), len(config.InstalledVersions))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that the installed versions of a version 1 config are migrated to installations.
+func TestMigrateInstallations(t *testing.T) {
+	dir := t.TempDir()
+	applyProfile(builtinProfiles[0])
+	setDataDir(dir)
+	defer setDataDir("data")
+	outputDir := filepath.Join(dir, "ISOs")
+	os.Mkdir(outputDir, 0755)
+	os.WriteFile(filepath.Join(outputDir, "SCON4-1.6.1.iso"), []byte("iso"), 0644)
+	v1 := `{"version": 1, "output_dir": "` + filepath.ToSlash(outputDir) + `", "current_version": "1.6.1", "installed_versions": ["1.6.0", "1.6.1"]}`
+	os.WriteFile(ConfigFile, []byte(v1), 0644)
+	loadConfig()
+
+	if config.Version != ConfigVersion || len(config.InstalledVersions) != 2 {
+		t.Fatalf("Unexpected config %+v", config)
+	}
+	if config.InstalledVersions[0].Version != "1.6.0" || config.InstalledVersions[0].Path != "" {
+		t.Errorf("Expected 1.6.0 without an ISO but got %+v", config.InstalledVersions[0])
+	}
+	installation := config.InstalledVersions[1]
+	if installation.Version != "1.6.1" || !exists(installation.Path) || installation.InstalledAt.IsZero() {
+		t.Errorf("Expected 1.6.1 with its ISO but got %+v", installation)
+	}
+}
+
+// Test rolling back to the previous installed version and pruning old versions.
+func TestRollbackAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	applyProfile(builtinProfiles[0])
+	setDataDir(dir)
+	defer setDataDir("data")
+	config = Config{}
+	for _, version := range []string{"v1.2.0", "v1.0.0", "v1.1.0", "v1.3.0"} {
+		isoPath := filepath.Join(dir, "SCON4-"+version+".iso")
+		os.WriteFile(isoPath, []byte(version), 0644)
+		config.InstalledVersions = append(config.InstalledVersions, Installation{Version: version, Path: isoPath})
+	}
+	config.CurrentVersion = "v1.3.0"
+
+	previous, found := previousInstallation()
+	if !found || previous.Version != "v1.2.0" {
+		t.Fatalf("Expected to roll back to v1.2.0 but got %s", previous.Version)
+	}
+	os.Remove(previous.Path)
+	previous, found = previousInstallation()
+	if !found || previous.Version != "v1.1.0" {
+		t.Fatalf("Expected to roll back to v1.1.0 when the ISO of v1.2.0 is gone but got %s", previous.Version)
+	}
+
+	pruneInstallations(1)
+	versions := installedVersions()
+	if len(versions) != 2 || versions[0] != "v1.1.0" || versions[1] != "v1.3.0" {
+		t.Fatalf("Expected v1.1.0 and v1.3.0 to be kept but got %v", versions)
+	}
+	if exists(filepath.Join(dir, "SCON4-v1.0.0.iso")) {
+		t.Error("Expected the ISO of v1.0.0 to be deleted")
+	}
+}
+
+// Test that prune keeps the most recently installed versions, even when a reinstalled version
+// kept its earlier place in the installed versions.
+func TestPruneByInstallDate(t *testing.T) {
+	dir := t.TempDir()
+	setDataDir(dir)
+	defer setDataDir("data")
+	defer func() { config = Config{} }()
+	config = Config{}
+	now := time.Now()
+	installed := map[string]time.Time{"v1.0.0": now.Add(-time.Hour), "v1.1.0": now.Add(-2 * time.Hour), "v1.2.0": now}
+	for _, version := range []string{"v1.0.0", "v1.1.0", "v1.2.0"} {
+		isoPath := filepath.Join(dir, "SCON4-"+version+".iso")
+		os.WriteFile(isoPath, []byte(version), 0644)
+		config.InstalledVersions = append(config.InstalledVersions, Installation{Version: version, Path: isoPath, InstalledAt: installed[version]})
+	}
+	config.CurrentVersion = "v1.2.0"
+
+	pruneInstallations(1)
+	if versions := installedVersions(); len(versions) != 2 || versions[0] != "v1.0.0" || versions[1] != "v1.2.0" {
+		t.Fatalf("Expected the reinstalled v1.0.0 and v1.2.0 to be kept but got %v", versions)
+	}
+}
@@ -2,11 +2,10 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-
-	"github.com/cheggaaa/pb/v3"
 )
 
 // hdrIndicator
@@ -19,6 +18,9 @@ const VCD_SOURCE = 0x01
 const VCD_TARGET = 0x02
 const VCD_ADLER32 = 0x04
 
+// ErrChecksumMismatch a patched window does not match the checksum in the patch
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 /*
 	build the default code table (used to encode/decode instructions) specified in RFC 3284
 	heavily based on
@@ -65,15 +67,28 @@ type AddressCache struct {
 	same          []int
 }
 
+// xdeltaOutput where a patch writes the patched data to. The data is written in order, and read
+// back only by patches that copy from earlier windows of the target.
+type xdeltaOutput interface {
+	io.Writer
+	io.ReaderAt
+}
+
 // Convert an input into and output with a patch. Validate each chunk via checksums if desired.
 // The input is a io.ReadSeeker to allow either bytes or a file to be used, since we may
 // need to convert bytes in-memory before we call this method.
 func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, validate bool) {
 
-	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE, 0644)
+	output, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	check(err)
 	defer output.Close() // TODO: https://www.joeshaw.org/dont-defer-close-on-writable-files/
 
+	patchXdeltaTo(input, output, patchPath, validate)
+}
+
+// Patch an input and write the result to an output in order, one target window at a time, so
+// that the output can compress it as it comes in.
+func patchXdeltaTo(input io.ReadSeeker, output xdeltaOutput, patchPath string, validate bool) {
 	patch, err := os.Open(patchPath)
 	check(err)
 	defer patch.Close()
@@ -93,9 +108,7 @@ func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, v
 	}
 
 	// Create progress bar
-	bar := pb.StartNew(newFileSize)
-	bar.Set(pb.Bytes, true)
-	bar.Set(pb.SIBytesPrefix, true)
+	bar := startProgress("patch-progress", int64(newFileSize))
 
 	patch.Seek(int64(headerEndOffset), io.SeekStart)
 
@@ -106,6 +119,7 @@ func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, v
 	// Loop over xdelta windows
 	for !isEOF(patch) {
 		winHeader := decodeWindowHeader(patch)
+		window := make([]byte, winHeader.targetWindowLength)
 
 		addRunDataStream, err := os.Open(patchPath)
 		check(err)
@@ -139,6 +153,9 @@ func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, v
 				if size == 0 && instruction.codeType != VCD_NOOP {
 					size = read7BitEncodedInt(instructionsStream)
 				}
+				if instruction.codeType != VCD_NOOP && addRunDataIndex+size > len(window) {
+					panic(fmt.Errorf("instruction of %d bytes overflows the target window", size))
+				}
 
 				if instruction.codeType == VCD_NOOP {
 					//fmt.Println("VCD_NOOP")
@@ -146,77 +163,54 @@ func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, v
 
 				} else if instruction.codeType == VCD_ADD {
 					//fmt.Printf("VCD_ADD (%d)\n", size)
-					copyToFile2(addRunDataStream, output, addRunDataIndex+targetWindowPosition, size)
+					_, err := io.ReadFull(addRunDataStream, window[addRunDataIndex:addRunDataIndex+size])
+					check(err)
 					addRunDataIndex += size
 
 				} else if instruction.codeType == VCD_COPY {
 					//fmt.Printf("VCD_COPY (%d)\n", size)
 					var addr = decodeAddress(&cache, addRunDataIndex+winHeader.sourceLength, instruction.mode)
-					var absAddr = 0
 
-					var sourceData io.ReadSeeker
 					if addr < winHeader.sourceLength {
-						absAddr = winHeader.sourcePosition + addr
-						//fmt.Printf("  absAddr = %d\n", absAddr)
+						// Copy from the source segment, which may run on into the target window
+						length := size
+						if addr+length > winHeader.sourceLength {
+							length = winHeader.sourceLength - addr
+						}
+						buff := window[addRunDataIndex : addRunDataIndex+length]
+						absAddr := int64(winHeader.sourcePosition + addr)
 						if winHeader.indicator&VCD_SOURCE != 0 {
 							//fmt.Println("  VCD_SOURCE")
-							sourceData = input
+							_, err := input.Seek(absAddr, io.SeekStart)
+							check(err)
+							_, err = io.ReadFull(input, buff)
+							check(err)
 						} else if winHeader.indicator&VCD_TARGET != 0 {
 							//fmt.Println("  VCD_TARGET")
-							sourceData = output
+							_, err := output.ReadAt(buff, absAddr)
+							check(err)
 						}
-					} else {
-						absAddr = targetWindowPosition + (addr - winHeader.sourceLength)
-						//fmt.Printf("  absAddr = %d\n", absAddr)
-						sourceData = output
+						addRunDataIndex += length
+						size -= length
+						addr = winHeader.sourceLength
 					}
 
-					distance := (targetWindowPosition + addRunDataIndex) - absAddr
-					if sourceData == output && size > distance {
-						// Slow copy that can handle overlap of reading and writing targets
-						// This functionality is usually used to create repeating byte sequences in the target
-						repeatLength := size - distance
-						totalSize := size
-						inputBytes := make([]byte, distance)
-						outputBytes := make([]byte, size)
-						sourceData.Seek(int64(absAddr), io.SeekStart)
-						sourceData.Read(inputBytes) // Read the bytes that we will be repeating
-						// Repeatedly iterate over inputBytes and write to outputBytes
-						i := 0
-						j := 0
-						for size > 0 {
-							if i == len(inputBytes) {
-								i = 0
-							}
-							outputBytes[j] = inputBytes[i]
-							size--
-							i++
-							j++
-						}
-						sourceData.Seek(int64(repeatLength), io.SeekCurrent) // Skip repeated bytes we didn't end up reading
-						output.WriteAt(outputBytes, int64(targetWindowPosition+addRunDataIndex))
-						addRunDataIndex += totalSize
-						absAddr += totalSize
-					} else {
-						// No overlap, fast copy
-						buff := make([]byte, size)
-						sourceData.Seek(int64(absAddr), io.SeekStart)
-						sourceData.Read(buff)
-						output.WriteAt(buff, int64(targetWindowPosition+addRunDataIndex))
-						addRunDataIndex += size
-						absAddr += size
+					// Copy from earlier in the target window one byte at a time, since the bytes
+					// may overlap the ones being written to repeat a sequence
+					from := addr - winHeader.sourceLength
+					for j := 0; j < size; j++ {
+						window[addRunDataIndex+j] = window[from+j]
 					}
+					addRunDataIndex += size
 
 				} else if instruction.codeType == VCD_RUN {
 					//fmt.Printf("VCD_RUN (%d)\n", size)
 					runByte := readU8(addRunDataStream)
-					offset := targetWindowPosition + addRunDataIndex
-					//fmt.Printf("  runByte = %d offset = %d\n", runByte, offset)
-					buffer := make([]byte, size)
+					//fmt.Printf("  runByte = %d offset = %d\n", runByte, addRunDataIndex)
+					buffer := window[addRunDataIndex : addRunDataIndex+size]
 					for i := range buffer {
 						buffer[i] = runByte
 					}
-					output.WriteAt(buffer, int64(offset))
 
 					addRunDataIndex += size
 				} else {
@@ -227,11 +221,13 @@ func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, v
 
 		//fmt.Println("Check CRC")
 		if validate && winHeader.hasAdler32 {
-			current := adler32(output, targetWindowPosition, winHeader.targetWindowLength)
+			current := _adler32(window)
 			if winHeader.adler32 != current {
-				panic(fmt.Sprintf("Failed CRC check: Got %X but expected %X\n", current, winHeader.adler32))
+				panic(fmt.Errorf("%w: Got %X but expected %X", ErrChecksumMismatch, current, winHeader.adler32))
 			}
 		}
+		_, err = output.Write(window)
+		check(err)
 
 		patch.Seek(int64(winHeader.addRunDataLength+winHeader.addressesLength+winHeader.instructionsLength), io.SeekCurrent)
 		targetWindowPosition += winHeader.targetWindowLength
@@ -241,27 +237,10 @@ func patchWithXdelta(input io.ReadSeeker, outputPath string, patchPath string, v
 	bar.Finish()
 }
 
-func copyToFile2(stream *os.File, output *os.File, targetOffset int, len int) {
-	buffer := make([]byte, len)
-	stream.Read(buffer)
-	output.WriteAt(buffer, int64(targetOffset))
-}
-
 // ADD TEST FOR THIS
 /* Adler-32 - https://en.wikipedia.org/wiki/Adler-32#Example_implementation */
 const ADLER32_MOD = 0xfff1
 
-func adler32(file *os.File, offset int, len int) uint32 {
-	bytes := make([]byte, len)
-	n, err := file.ReadAt(bytes, int64(offset))
-	check(err)
-	if n != len {
-		panic(fmt.Sprintf("Failed to read %d bytes but instead read %d", len, n))
-	}
-
-	return _adler32(bytes)
-}
-
 func _adler32(byteSlice []byte) uint32 {
 	a := 1
 	b := 0
@@ -386,6 +365,30 @@ func getDefaultCodeTable() [][]Code {
 	return entries
 }
 
+// Get the size of the file a patch creates from the headers of its windows, without applying it.
+func xdeltaTargetSize(patchPath string) (size int64, err error) {
+	patch, err := os.Open(patchPath)
+	if err != nil {
+		return 0, err
+	}
+	defer patch.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid patch: %v", r)
+		}
+	}()
+
+	parseHeader(patch)
+	for !isEOF(patch) {
+		winHeader := decodeWindowHeader(patch)
+		size += int64(winHeader.targetWindowLength)
+		length := int64(winHeader.addRunDataLength + winHeader.addressesLength + winHeader.instructionsLength)
+		_, err := patch.Seek(length, io.SeekCurrent)
+		check(err)
+	}
+	return size, nil
+}
+
 func parseHeader(reader io.ReadSeeker) {
 	_, err := reader.Seek(0x4, io.SeekStart)
 	check(err)
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -47,6 +48,23 @@ func TestImageDelta(t *testing.T) {
 	runXdeltaAndCompare(inputPath, tempPath, patchPath, outputPath, t)
 }
 
+// Test that the size of the output of a patch is read from its window headers.
+func TestXdeltaTargetSize(t *testing.T) {
+	for _, dir := range []string{"test/TextDelta", "test/ImageDelta"} {
+		size, err := xdeltaTargetSize(dir + "/patch.xdelta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches, _ := filepath.Glob(dir + "/output.*")
+		if expected := getFileSize(matches[0]); size != expected {
+			t.Errorf("Expected %d bytes for %s but got %d", expected, dir, size)
+		}
+	}
+	if _, err := xdeltaTargetSize("test/TextDelta/input.txt"); err == nil {
+		t.Error("Expected an error for a file that isn't a patch")
+	}
+}
+
 func TestBinaryDeltaWithMultipleWindows(t *testing.T) {
 	inputPath := "test/BinaryDelta/DAT.Texture.Wizard.-.v6.1.3.x64.zip"
 	outputPath := "test/BinaryDelta/DAT.Texture.Wizard.-.v6.1.4.x64.zip"